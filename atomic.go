@@ -0,0 +1,150 @@
+package httpfile
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CollisionPolicy decides what happens when the file name of a download
+// taken from the response already exists.
+type CollisionPolicy int
+
+// Collision policies.
+const (
+	// CollisionOverwrite replaces the existing file.
+	CollisionOverwrite CollisionPolicy = iota
+	// CollisionSkip keeps the existing file and does not download.
+	CollisionSkip
+	// CollisionRename saves to "name (1).ext", "name (2).ext" and so on.
+	CollisionRename
+	// CollisionFail returns ErrFileExists.
+	CollisionFail
+)
+
+// ErrFileExists is returned by CollisionFail when the file already exists.
+var ErrFileExists = errors.New("File already exists")
+
+// downloadFile is a temporary file next to the destination of a download,
+// it is renamed into place by Commit only when the download succeeded.
+type downloadFile struct {
+	*os.File
+	path string
+	// keep the temporary file on Abort, so the download can be resumed.
+	keep bool
+	// collision applies to an existing file at path on Commit.
+	collision CollisionPolicy
+}
+
+// createDownloadFile creates a uniquely named temporary file for path.
+func createDownloadFile(path string) (*downloadFile, error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &downloadFile{File: f, path: path}, nil
+}
+
+// openPartFile opens path.part, which is kept on Abort. The file is
+// truncated unless appending to a partial download.
+func openPartFile(path string, append bool) (*downloadFile, error) {
+	flag := os.O_WRONLY | os.O_CREATE
+	if append {
+		flag |= os.O_APPEND
+	} else {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(partPath(path), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &downloadFile{File: f, path: path, keep: true}, nil
+}
+
+func partPath(path string) string {
+	return path + ".part"
+}
+
+// Commit flushes the temporary file to disk and renames it to the
+// destination. A file created at the destination meanwhile is handled by
+// the collision policy, CollisionSkip returns errSkipped.
+func (f *downloadFile) Commit() error {
+	err := f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	reserved := false
+	if err == nil {
+		switch f.collision {
+		case CollisionRename:
+			f.path, err = reserveUnique(f.path)
+			reserved = err == nil
+		case CollisionFail, CollisionSkip:
+			err = reserve(f.path)
+			reserved = err == nil
+			if err == ErrFileExists && f.collision == CollisionSkip {
+				err = errSkipped
+			}
+		}
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+		if err != nil && reserved {
+			os.Remove(f.path)
+		}
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// reserve creates an empty file at path unless it exists, so a following
+// rename can not replace a file created meanwhile.
+func reserve(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return ErrFileExists
+	}
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// reserveUnique reserves path, or "name (n).ext" with the first free n.
+func reserveUnique(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	for n := 1; ; n++ {
+		err := reserve(path)
+		if err != ErrFileExists {
+			return path, err
+		}
+		path = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+}
+
+// Abort closes the temporary file and removes it unless it is kept for
+// resuming.
+func (f *downloadFile) Abort() {
+	if f.keep {
+		f.Sync()
+	}
+	f.Close()
+	if !f.keep {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,226 @@
+package httpfile
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Authorizer authorizes a request right before it is sent. It is called for
+// every attempt and redirect to the same host with a copy of the request,
+// so it may set headers and replace the body. Redirects to another host are
+// sent without authorization.
+type Authorizer interface {
+	Authorize(request *http.Request) error
+}
+
+// Challenger is an Authorizer answering the challenge of a 401 response.
+// Challenge reports whether the request is authorized and sent once again.
+// A body that can not be read again is sent with "Expect: 100-continue", so
+// the server can reject it before it is consumed.
+type Challenger interface {
+	Authorizer
+	Challenge(resp *http.Response) bool
+}
+
+// AuthorizerFunc is a function used as Authorizer.
+type AuthorizerFunc func(request *http.Request) error
+
+// Authorize implements Authorizer.
+func (fn AuthorizerFunc) Authorize(request *http.Request) error {
+	return fn(request)
+}
+
+// BasicAuth returns an Authorizer sending username and password by the
+// Basic scheme.
+func BasicAuth(username, password string) Authorizer {
+	return AuthorizerFunc(func(request *http.Request) error {
+		request.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+// TokenSource provides bearer tokens. refresh is true after the server
+// rejected the last token with 401.
+type TokenSource interface {
+	Token(refresh bool) (string, error)
+}
+
+// TokenSourceFunc is a function used as TokenSource.
+type TokenSourceFunc func(refresh bool) (string, error)
+
+// Token implements TokenSource.
+func (fn TokenSourceFunc) Token(refresh bool) (string, error) {
+	return fn(refresh)
+}
+
+// BearerAuth returns an Authorizer sending the tokens of src. A 401 response
+// refreshes the token and sends the request once again.
+func BearerAuth(src TokenSource) Challenger {
+	return &bearerAuth{src: src}
+}
+
+type bearerAuth struct {
+	mu      sync.Mutex
+	src     TokenSource
+	refresh bool
+}
+
+func (b *bearerAuth) Authorize(request *http.Request) error {
+	b.mu.Lock()
+	refresh := b.refresh
+	b.refresh = false
+	b.mu.Unlock()
+	token, err := b.src.Token(refresh)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (b *bearerAuth) Challenge(resp *http.Response) bool {
+	b.mu.Lock()
+	b.refresh = true
+	b.mu.Unlock()
+	return true
+}
+
+type authTransport struct {
+	base http.RoundTripper
+	auth Authorizer
+}
+
+func (t *authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if redirectedAway(request) {
+		return t.transport().RoundTrip(request)
+	}
+	challenger, _ := t.auth.(Challenger)
+	clone := request.Clone(request.Context())
+	var body *replayBody
+	if challenger != nil && request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		body = &replayBody{body: request.Body}
+		clone.Body = body
+		clone.Header.Set("Expect", "100-continue")
+	}
+	resp, err := t.send(request, clone)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || challenger == nil || !challenger.Challenge(resp) {
+		if body != nil {
+			body.release()
+		}
+		return resp, err
+	}
+	retry := request.Clone(request.Context())
+	switch {
+	case request.GetBody != nil:
+		if retry.Body, err = request.GetBody(); err != nil {
+			return resp, nil
+		}
+	case body != nil:
+		if !body.reuse() {
+			return resp, nil
+		}
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body.Close()
+	return t.send(request, retry)
+}
+
+// send authorizes clone of request and sends it.
+func (t *authTransport) send(request, clone *http.Request) (*http.Response, error) {
+	if err := t.auth.Authorize(clone); err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+	return t.transport().RoundTrip(clone)
+}
+
+func (t *authTransport) transport() http.RoundTripper {
+	if t.base == nil {
+		return http.DefaultTransport
+	}
+	return t.base
+}
+
+// redirectedAway reports whether request was redirected to another host
+// than the first request of the chain. It is not authorized, like net/http
+// drops the Authorization header on such redirects.
+func redirectedAway(request *http.Request) bool {
+	first := request
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+	return !strings.EqualFold(first.URL.Host, request.URL.Host)
+}
+
+// replayBody keeps a body open after the transport closes it, so it can be
+// sent again if none of it was read.
+type replayBody struct {
+	// readMu is held while reading, so reuse waits for a read in progress.
+	readMu   sync.Mutex
+	mu       sync.Mutex
+	body     io.ReadCloser
+	read     bool
+	closed   bool
+	released bool
+	reused   bool
+}
+
+func (r *replayBody) Read(p []byte) (int, error) {
+	r.readMu.Lock()
+	defer r.readMu.Unlock()
+	r.mu.Lock()
+	reused := r.reused
+	r.mu.Unlock()
+	if reused {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.mu.Lock()
+		r.read = true
+		r.mu.Unlock()
+	}
+	return n, err
+}
+
+func (r *replayBody) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	if r.released {
+		return r.body.Close()
+	}
+	return nil
+}
+
+// release gives up sending the body again, it is closed once the transport
+// closed it.
+func (r *replayBody) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.released = true
+	if r.closed {
+		r.body.Close()
+	}
+}
+
+// reuse reports whether the body can be sent again, it is released if not.
+func (r *replayBody) reuse() bool {
+	r.readMu.Lock()
+	defer r.readMu.Unlock()
+	r.mu.Lock()
+	read := r.read
+	if !read {
+		r.reused = true
+	}
+	r.mu.Unlock()
+	if read {
+		r.release()
+	}
+	return !read
+}
@@ -0,0 +1,54 @@
+package httpfile
+
+import (
+	"net/http"
+	"os"
+)
+
+func cacheMetaPath(filePath string) string {
+	return filePath + ".meta"
+}
+
+// cachedMeta returns the validators stored for filePath by a conditional
+// download, or nil if the file or its validators are missing.
+func cachedMeta(filePath string) *fileMeta {
+	if filePath == "" {
+		return nil
+	}
+	if _, err := os.Stat(filePath); err != nil {
+		return nil
+	}
+	meta, err := readFileMeta(cacheMetaPath(filePath))
+	if err != nil || meta.ETag == "" && meta.LastModified == "" {
+		return nil
+	}
+	return meta
+}
+
+// setConditional makes request return 304 Not Modified if the remote file
+// still matches m.
+func (m *fileMeta) setConditional(request *http.Request) {
+	if m == nil {
+		return
+	}
+	if m.ETag != "" {
+		request.Header.Set("If-None-Match", m.ETag)
+	}
+	if m.LastModified != "" {
+		request.Header.Set("If-Modified-Since", m.LastModified)
+	}
+}
+
+// storeCacheMeta records the validators of a committed download, so the next
+// conditional download can be skipped when nothing changed.
+func storeCacheMeta(filePath string, header http.Header) error {
+	meta := newFileMeta(header)
+	if meta.ETag == "" && meta.LastModified == "" {
+		err := os.Remove(cacheMetaPath(filePath))
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	return writeFileMeta(cacheMetaPath(filePath), meta)
+}
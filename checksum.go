@@ -0,0 +1,243 @@
+package httpfile
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Checksum algorithms.
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+	CRC32C = "crc32c"
+)
+
+// Checksum is the expected digest of a download.
+type Checksum struct {
+	Algorithm string
+	// Hex is the digest in hexadecimal.
+	Hex string
+}
+
+// ChecksumError is returned when the digest of a download does not match,
+// the file is removed.
+type ChecksumError struct {
+	Algorithm string
+	// Source is "expected" or the response header the digest came from.
+	Source   string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch (%s): expected %s, got %s", e.Algorithm, e.Source, e.Expected, e.Actual)
+}
+
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case MD5:
+		return md5.New()
+	case SHA1:
+		return sha1.New()
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	}
+	return nil
+}
+
+// digestAlgorithms maps the names of Digest and Repr-Digest to algorithms.
+var digestAlgorithms = map[string]string{
+	"md5":     MD5,
+	"sha":     SHA1,
+	"sha-256": SHA256,
+	"sha-512": SHA512,
+	"crc32c":  CRC32C,
+}
+
+type expectedDigest struct {
+	algorithm string
+	source    string
+	digest    []byte
+}
+
+// verifier computes the digests of a download while it is written and
+// compares them with the expected ones.
+type verifier struct {
+	expected []expectedDigest
+	hashes   map[string]hash.Hash
+	writer   io.Writer
+}
+
+// newVerifier collects the digests expected by sum and, if advertised is
+// true, the digests advertised by resp. Content-MD5 only describes the whole
+// file if full is true. The advertised digests describe the encoded bytes,
+// they are ignored if the transport decoded the body. It returns nil if
+// there is nothing to verify.
+func newVerifier(sum Checksum, resp *http.Response, full, advertised bool) (*verifier, error) {
+	v := &verifier{hashes: make(map[string]hash.Hash)}
+	if sum.Algorithm != "" {
+		digest, err := hex.DecodeString(sum.Hex)
+		if err != nil {
+			return nil, err
+		}
+		if err = v.expect(strings.ToLower(sum.Algorithm), "expected", digest); err != nil {
+			return nil, err
+		}
+	}
+	if advertised && !resp.Uncompressed {
+		v.expectAdvertised(resp.Header, full)
+	}
+	if len(v.expected) == 0 {
+		return nil, nil
+	}
+	writers := make([]io.Writer, 0, len(v.hashes))
+	for _, h := range v.hashes {
+		writers = append(writers, h)
+	}
+	v.writer = io.MultiWriter(writers...)
+	return v, nil
+}
+
+// expectAdvertised collects the digests of Repr-Digest, Digest, Content-MD5
+// and of an ETag that is a hex MD5.
+func (v *verifier) expectAdvertised(header http.Header, full bool) {
+	for algorithm, digest := range parseDigestHeader(header.Get("Repr-Digest"), true) {
+		v.expect(algorithm, "Repr-Digest", digest)
+	}
+	for algorithm, digest := range parseDigestHeader(header.Get("Digest"), false) {
+		v.expect(algorithm, "Digest", digest)
+	}
+	if full {
+		if digest, err := base64.StdEncoding.DecodeString(header.Get("Content-MD5")); err == nil && len(digest) == md5.Size {
+			v.expect(MD5, "Content-MD5", digest)
+		}
+	}
+	if digest, ok := etagMD5(header.Get("ETag")); ok {
+		v.expect(MD5, "ETag", digest)
+	}
+}
+
+func (v *verifier) expect(algorithm, source string, digest []byte) error {
+	if _, ok := v.hashes[algorithm]; !ok {
+		h := newHash(algorithm)
+		if h == nil {
+			return fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+		}
+		v.hashes[algorithm] = h
+	}
+	v.expected = append(v.expected, expectedDigest{algorithm, source, digest})
+	return nil
+}
+
+// wrap returns w writing to the hashes as well.
+func (v *verifier) wrap(w io.Writer) io.Writer {
+	if v == nil {
+		return w
+	}
+	return io.MultiWriter(w, v.writer)
+}
+
+// hashFile hashes the first n bytes of path, n < 0 hashes the whole file.
+func (v *verifier) hashFile(path string, n int64) error {
+	if v == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if n < 0 {
+		_, err = io.Copy(v.writer, f)
+	} else {
+		_, err = io.CopyN(v.writer, f, n)
+	}
+	return err
+}
+
+func (v *verifier) verify() error {
+	if v == nil {
+		return nil
+	}
+	for _, e := range v.expected {
+		actual := v.hashes[e.algorithm].Sum(nil)
+		if !bytes.Equal(actual, e.digest) {
+			return &ChecksumError{
+				Algorithm: e.algorithm,
+				Source:    e.source,
+				Expected:  hex.EncodeToString(e.digest),
+				Actual:    hex.EncodeToString(actual),
+			}
+		}
+	}
+	return nil
+}
+
+// digests returns the computed digests in hexadecimal by algorithm.
+func (v *verifier) digests() map[string]string {
+	if v == nil {
+		return nil
+	}
+	digests := make(map[string]string, len(v.hashes))
+	for algorithm, h := range v.hashes {
+		digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests
+}
+
+// parseDigestHeader parses "sha-256=base64, md5=base64" of Digest (RFC
+// 3230), or "sha-256=:base64:" of Repr-Digest (RFC 9530) if structured.
+// Unknown algorithms are ignored.
+func parseDigestHeader(s string, structured bool) map[string][]byte {
+	digests := make(map[string][]byte)
+	for _, item := range strings.Split(s, ",") {
+		i := strings.IndexByte(item, '=')
+		if i < 0 {
+			continue
+		}
+		algorithm, ok := digestAlgorithms[strings.ToLower(strings.TrimSpace(item[:i]))]
+		if !ok {
+			continue
+		}
+		value := strings.TrimSpace(item[i+1:])
+		if structured {
+			if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+				continue
+			}
+			value = value[1 : len(value)-1]
+		}
+		if digest, err := base64.StdEncoding.DecodeString(value); err == nil {
+			digests[algorithm] = digest
+		}
+	}
+	return digests
+}
+
+// etagMD5 returns the digest of an ETag that is a hex MD5, like the ETag
+// of S3 objects not uploaded in parts.
+func etagMD5(etag string) ([]byte, bool) {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != hex.EncodedLen(md5.Size) {
+		return nil, false
+	}
+	digest, err := hex.DecodeString(etag)
+	return digest, err == nil
+}
@@ -0,0 +1,367 @@
+// Command httpfile uploads and downloads files over HTTP.
+//
+//	httpfile get URL [-o path [-resume] | -d dir] [-H header] [-checksum alg:hex]
+//	httpfile put FILE URL [-form | -stream] [-F field=value] [-H header] [-X method]
+//	httpfile head URL [-H header]
+//
+// Flags may follow the arguments. Progress is drawn on stderr when it is a
+// terminal, -json prints the result as JSON on stdout instead of text.
+//
+// Exit codes: 0 success, 1 error, 2 usage, 3 network error, 4 4xx
+// response, 5 5xx response.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mushroomsir/httpfile"
+)
+
+const (
+	exitOK = iota
+	exitError
+	exitUsage
+	exitNetwork
+	exitClientError
+	exitServerError
+)
+
+const usage = `usage:
+  httpfile get URL [-o path | -d dir] [flags]
+  httpfile put FILE URL [-form | -stream] [-F field=value] [flags]
+  httpfile head URL [flags]
+
+Run "httpfile COMMAND -h" for the flags of a command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command of args and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	var cmd *command
+	switch args[0] {
+	case "get":
+		cmd = newCommand("get", 1, stdout, stderr)
+		cmd.flags.StringVar(&cmd.output, "o", "", "save the file to `path`")
+		cmd.flags.StringVar(&cmd.dir, "d", "", "save the file to `dir` under the name sent by the server")
+		cmd.flags.BoolVar(&cmd.resume, "resume", false, "continue a partial file, requires -o")
+		cmd.flags.StringVar(&cmd.checksum, "checksum", "", "verify the file against `algorithm:hex`")
+		cmd.run = cmd.get
+	case "put":
+		cmd = newCommand("put", 2, stdout, stderr)
+		cmd.flags.BoolVar(&cmd.form, "form", false, "send the file by multipart form, the default")
+		cmd.flags.BoolVar(&cmd.stream, "stream", false, "send the file as the raw body")
+		cmd.flags.Var(&cmd.fields, "F", "add the form `field=value`, may be repeated")
+		cmd.flags.StringVar(&cmd.method, "X", http.MethodPost, "request `method`")
+		cmd.run = cmd.put
+	case "head":
+		cmd = newCommand("head", 1, stdout, stderr)
+		cmd.run = cmd.head
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "httpfile: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+	if err := cmd.parse(args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		fmt.Fprintf(stderr, "httpfile %s: %v\n", cmd.name, err)
+		return exitUsage
+	}
+	return cmd.run()
+}
+
+// command is a subcommand with its flags and arguments.
+type command struct {
+	name   string
+	nargs  int
+	flags  *flag.FlagSet
+	args   []string
+	stdout io.Writer
+	stderr io.Writer
+	run    func() int
+
+	headers  listFlag
+	retries  int
+	rate     int64
+	jsonOut  bool
+	quiet    bool
+	output   string
+	dir      string
+	resume   bool
+	checksum string
+	form     bool
+	stream   bool
+	fields   listFlag
+	method   string
+}
+
+func newCommand(name string, nargs int, stdout, stderr io.Writer) *command {
+	c := &command{
+		name:   name,
+		nargs:  nargs,
+		flags:  flag.NewFlagSet("httpfile "+name, flag.ContinueOnError),
+		stdout: stdout,
+		stderr: stderr,
+	}
+	c.flags.SetOutput(stderr)
+	c.flags.Var(&c.headers, "H", "add the request `header` \"Name: value\", may be repeated")
+	c.flags.IntVar(&c.retries, "retries", 0, "retry failed requests `n` times")
+	c.flags.Int64Var(&c.rate, "rate", 0, "limit the transfer to `bytes` per second")
+	c.flags.BoolVar(&c.jsonOut, "json", false, "print the result as JSON")
+	c.flags.BoolVar(&c.quiet, "q", false, "do not draw progress")
+	return c
+}
+
+// parse parses args, flags may be mixed with the arguments.
+func (c *command) parse(args []string) error {
+	for {
+		if err := c.flags.Parse(args); err != nil {
+			return err
+		}
+		args = c.flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		c.args = append(c.args, args[0])
+		args = args[1:]
+	}
+	if len(c.args) != c.nargs {
+		return fmt.Errorf("expected %d arguments, got %d", c.nargs, len(c.args))
+	}
+	if c.output != "" && c.dir != "" {
+		return errors.New("-o and -d are exclusive")
+	}
+	if c.resume && c.output == "" {
+		return errors.New("-resume requires -o")
+	}
+	if c.form && c.stream {
+		return errors.New("-form and -stream are exclusive")
+	}
+	if len(c.fields) > 0 && c.stream {
+		return errors.New("-F requires -form")
+	}
+	for _, h := range c.headers {
+		if !strings.Contains(h, ":") {
+			return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+	}
+	for _, f := range c.fields {
+		if !strings.Contains(f, "=") {
+			return fmt.Errorf("invalid field %q, expected field=value", f)
+		}
+	}
+	return nil
+}
+
+// request returns the request to targetURL with the common flags.
+func (c *command) request(targetURL, filePath string) *httpfile.Files {
+	req := httpfile.NewReq(targetURL, filePath).SetRateLimit(c.rate)
+	if c.retries > 0 {
+		req.SetRetryPolicy(httpfile.NewBackoff(c.retries))
+	}
+	for _, h := range c.headers {
+		i := strings.IndexByte(h, ':')
+		req.SetHeader(strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]))
+	}
+	if !c.quiet && !c.jsonOut && isTerminal(c.stderr) {
+		bar := &progressBar{w: c.stderr}
+		req.SetProgress(bar.report).SetProgressInterval(100 * time.Millisecond)
+	}
+	return req
+}
+
+func (c *command) get() int {
+	targetURL := c.args[0]
+	req := c.request(targetURL, c.output).SetResume(c.resume)
+	if c.checksum != "" {
+		i := strings.IndexByte(c.checksum, ':')
+		if i < 0 {
+			fmt.Fprintf(c.stderr, "httpfile get: invalid checksum %q, expected algorithm:hex\n", c.checksum)
+			return exitUsage
+		}
+		req.SetChecksum(c.checksum[:i], c.checksum[i+1:])
+	}
+	var res *httpfile.Response
+	path := c.output
+	if path == "" {
+		dir := c.dir
+		if dir == "" {
+			dir = "."
+		}
+		res = req.DownloadToDir(dir)
+		if res.FileName() != "" {
+			path = filepath.Join(dir, res.FileName())
+		}
+	} else {
+		res = req.Download()
+	}
+	result := c.result(targetURL, res)
+	if res.Error() == nil {
+		result.File = path
+		if size, err := res.FileSize(); err == nil {
+			result.Bytes = size
+		}
+		result.Digests = res.Digests()
+		result.Skipped = res.Skipped()
+	}
+	return c.print(result, res.Error())
+}
+
+func (c *command) put() int {
+	filePath, targetURL := c.args[0], c.args[1]
+	req := c.request(targetURL, filePath).SetMethod(c.method)
+	for _, f := range c.fields {
+		i := strings.IndexByte(f, '=')
+		req.AddField(f[:i], f[i+1:])
+	}
+	var res *httpfile.Response
+	if c.stream {
+		res = req.UploadByStream()
+	} else {
+		res = req.Upload()
+	}
+	result := c.result(targetURL, res)
+	result.File = filePath
+	if stat, err := os.Stat(filePath); err == nil {
+		result.Bytes = stat.Size()
+	}
+	if res.Resp() != nil {
+		body, err := res.Bytes()
+		if err == nil {
+			result.Body = string(body)
+		}
+	}
+	return c.print(result, res.Error())
+}
+
+func (c *command) head() int {
+	targetURL := c.args[0]
+	res := c.request(targetURL, "").Head()
+	result := c.result(targetURL, res)
+	if res.Resp() != nil {
+		result.Header = res.Resp().Header
+		res.Close()
+	}
+	return c.print(result, res.Error())
+}
+
+// result is the outcome of a command, printed by -json.
+type result struct {
+	URL     string            `json:"url"`
+	Status  int               `json:"status,omitempty"`
+	File    string            `json:"file,omitempty"`
+	Bytes   int64             `json:"bytes,omitempty"`
+	Digests map[string]string `json:"digests,omitempty"`
+	Skipped bool              `json:"skipped,omitempty"`
+	Header  http.Header       `json:"header,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+func (c *command) result(targetURL string, res *httpfile.Response) *result {
+	r := &result{URL: targetURL, Status: res.StatusCode()}
+	if err := res.Error(); err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// print prints r and returns the exit code of err.
+func (c *command) print(r *result, err error) int {
+	if c.jsonOut {
+		enc := json.NewEncoder(c.stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(r)
+		return exitCode(err)
+	}
+	if err != nil {
+		fmt.Fprintf(c.stderr, "httpfile %s: %s\n", c.name, r.Error)
+		return exitCode(err)
+	}
+	switch c.name {
+	case "get":
+		if r.Skipped {
+			fmt.Fprintf(c.stdout, "%s exists, skipped\n", r.File)
+			break
+		}
+		fmt.Fprintf(c.stdout, "%s %d bytes\n", r.File, r.Bytes)
+		algorithms := make([]string, 0, len(r.Digests))
+		for algorithm := range r.Digests {
+			algorithms = append(algorithms, algorithm)
+		}
+		sort.Strings(algorithms)
+		for _, algorithm := range algorithms {
+			fmt.Fprintf(c.stdout, "%s:%s\n", algorithm, r.Digests[algorithm])
+		}
+	case "put":
+		io.WriteString(c.stdout, r.Body)
+	case "head":
+		fmt.Fprintf(c.stdout, "%d %s\n", r.Status, http.StatusText(r.Status))
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range r.Header[name] {
+				fmt.Fprintf(c.stdout, "%s: %s\n", name, v)
+			}
+		}
+	}
+	return exitOK
+}
+
+// exitCode maps err to the exit code, 4xx and 5xx responses have their own.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var httpErr *httpfile.HTTPError
+	if errors.As(err, &httpErr) {
+		switch {
+		case httpErr.StatusCode >= 500:
+			return exitServerError
+		case httpErr.StatusCode >= 400:
+			return exitClientError
+		}
+		return exitError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return exitNetwork
+	}
+	return exitError
+}
+
+// listFlag is a flag that may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
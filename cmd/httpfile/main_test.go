@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testModTime = time.Date(2018, 1, 18, 0, 0, 0, 0, time.UTC)
+
+func testServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file.txt":
+			w.Header().Set("X-Token", r.Header.Get("X-Token"))
+			w.Header().Set("Content-Disposition", `attachment; filename="served.txt"`)
+			http.ServeContent(w, r, "file.txt", testModTime, strings.NewReader("hello httpfile"))
+		case "/upload":
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				r.ParseMultipartForm(1 << 20)
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				data, _ := ioutil.ReadAll(file)
+				w.Write([]byte(r.Method + " form " + header.Filename + " " + string(data) + " " + r.FormValue("tag")))
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			w.Write([]byte(r.Method + " stream " + string(data)))
+		case "/broken":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func runArgs(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestGet(t *testing.T) {
+	require := require.New(t)
+	server := testServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "httpfile")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+
+	output := filepath.Join(dir, "out.txt")
+	code, _, _ := runArgs("get", server.URL+"/file.txt", "-o", output, "-checksum", "md5:1d3a7a1d4bf8ff4f8f1f6d2d1a3d0f9e")
+	require.Equal(exitError, code)
+	code, stdout, _ := runArgs("get", server.URL+"/file.txt", "-o", output)
+	require.Equal(exitOK, code)
+	require.Equal(output+" 14 bytes\n", stdout)
+	data, err := ioutil.ReadFile(output)
+	require.Nil(err)
+	require.Equal("hello httpfile", string(data))
+
+	code, stdout, _ = runArgs("get", "-json", server.URL+"/file.txt", "-d", dir)
+	require.Equal(exitOK, code)
+	var res result
+	require.Nil(json.Unmarshal([]byte(stdout), &res))
+	require.Equal(filepath.Join(dir, "served.txt"), res.File)
+	require.Equal(int64(14), res.Bytes)
+	require.Equal(200, res.Status)
+
+	code, stdout, _ = runArgs("get", "-json", server.URL+"/missing", "-o", output)
+	require.Equal(exitClientError, code)
+	require.Nil(json.Unmarshal([]byte(stdout), &res))
+	require.Equal(404, res.Status)
+	require.NotEmpty(res.Error)
+
+	code, _, stderr := runArgs("get", server.URL+"/broken", "-o", output)
+	require.Equal(exitServerError, code)
+	require.Contains(stderr, "500")
+
+	code, _, _ = runArgs("get", "http://127.0.0.1:1/file.txt", "-o", output)
+	require.Equal(exitNetwork, code)
+}
+
+func TestPut(t *testing.T) {
+	require := require.New(t)
+	server := testServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "httpfile")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "up.txt")
+	require.Nil(ioutil.WriteFile(file, []byte("payload"), 0644))
+
+	code, stdout, _ := runArgs("put", file, server.URL+"/upload", "-F", "tag=v1")
+	require.Equal(exitOK, code)
+	require.Equal("POST form up.txt payload v1", stdout)
+
+	code, stdout, _ = runArgs("put", "-stream", "-X", "PUT", file, server.URL+"/upload")
+	require.Equal(exitOK, code)
+	require.Equal("PUT stream payload", stdout)
+
+	code, stdout, _ = runArgs("put", "-json", file, server.URL+"/missing")
+	require.Equal(exitClientError, code)
+	var res result
+	require.Nil(json.Unmarshal([]byte(stdout), &res))
+	require.Equal(int64(7), res.Bytes)
+	require.Contains(res.Body, "404")
+}
+
+func TestHead(t *testing.T) {
+	require := require.New(t)
+	server := testServer()
+	defer server.Close()
+
+	code, stdout, _ := runArgs("head", server.URL+"/file.txt", "-H", "X-Token: secret")
+	require.Equal(exitOK, code)
+	require.True(strings.HasPrefix(stdout, "200 OK\n"))
+	require.Contains(stdout, "Content-Length: 14\n")
+	require.Contains(stdout, "X-Token: secret\n")
+}
+
+func TestUsage(t *testing.T) {
+	require := require.New(t)
+
+	for _, args := range [][]string{
+		{},
+		{"fetch"},
+		{"get"},
+		{"get", "a", "b"},
+		{"get", "url", "-o", "a", "-d", "b"},
+		{"get", "url", "-resume"},
+		{"get", "url", "-d", "b", "-resume"},
+		{"put", "file", "url", "-stream", "-F", "a=b"},
+		{"head", "url", "-H", "invalid"},
+	} {
+		code, _, _ := runArgs(args...)
+		require.Equal(exitUsage, code, "%q", args)
+	}
+	code, stdout, _ := runArgs("help")
+	require.Equal(exitOK, code)
+	require.Contains(stdout, "httpfile get URL")
+}
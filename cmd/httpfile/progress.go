@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mushroomsir/httpfile"
+)
+
+const barWidth = 30
+
+// progressBar draws the progress of a transfer on one terminal line.
+type progressBar struct {
+	w     io.Writer
+	width int
+}
+
+func (b *progressBar) report(p httpfile.Progress) {
+	var line string
+	if p.Total > 0 {
+		filled := int(float64(barWidth) * float64(p.Transferred) / float64(p.Total))
+		if filled > barWidth {
+			filled = barWidth
+		}
+		line = fmt.Sprintf("[%s%s] %3d%% %s/%s %s/s",
+			strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+			p.Transferred*100/p.Total, formatBytes(p.Transferred), formatBytes(p.Total), formatBytes(int64(p.Rate)))
+	} else {
+		line = fmt.Sprintf("%s %s/s", formatBytes(p.Transferred), formatBytes(int64(p.Rate)))
+	}
+	if p.ETA > 0 && !p.Done {
+		line += " ETA " + p.ETA.Round(time.Second).String()
+	}
+	// pad over the rest of a longer previous line
+	padding := b.width - len(line)
+	if padding < 0 {
+		padding = 0
+	}
+	b.width = len(line)
+	fmt.Fprintf(b.w, "\r%s%s", line, strings.Repeat(" ", padding))
+	if p.Done {
+		fmt.Fprintln(b.w)
+	}
+}
+
+// formatBytes formats n in binary units.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// isTerminal reports whether w is a character device, such as a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
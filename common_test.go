@@ -1,21 +1,37 @@
 package httpfile
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
 	"fmt"
+	"hash"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
 var (
-	testServer *httptest.Server
+	testServer  *httptest.Server
+	testETag    = `"test-gif"`
+	testModTime = time.Date(2018, 1, 18, 0, 0, 0, 0, time.UTC)
 )
 
 func TestMain(m *testing.M) {
@@ -29,6 +45,58 @@ func TestMain(m *testing.M) {
 }
 
 func uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/resume" {
+		resumeHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/gzip" {
+		gzipHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/flaky" {
+		flakyHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/inspect" {
+		inspectHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/slow" {
+		slowHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/form" {
+		formHandler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/tus") {
+		tusHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/s3" {
+		s3Handler(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/minio/") {
+		minioHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/bearer" {
+		bearerHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/digest" {
+		digestHandler(w, r)
+		return
+	}
+	if r.URL.Path == "/redirect" {
+		to := r.URL.Query().Get("to")
+		if to == "" {
+			to = "/resume"
+		}
+		http.Redirect(w, r, to, http.StatusFound)
+		return
+	}
 	if r.URL.Path != "/file" {
 		return
 	}
@@ -74,6 +142,519 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// resumeHandler serves testdata/test.gif with a fixed ETag, so Range and
+// If-Range requests can be verified. "set" adds "Name: value" headers.
+func resumeHandler(w http.ResponseWriter, r *http.Request) {
+	f, err := os.Open("testdata/test.gif")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("ETag", testETag)
+	for _, kv := range r.URL.Query()["set"] {
+		if i := strings.Index(kv, ": "); i > 0 {
+			w.Header().Set(kv[:i], kv[i+2:])
+		}
+	}
+	http.ServeContent(w, r, "test.gif", testModTime, f)
+}
+
+// gzipHandler serves testdata/test.gif gzip encoded, with the Digest and
+// the ETag of the encoded bytes.
+func gzipHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadFile("testdata/test.gif")
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	sum := md5.Sum(buf.Bytes())
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Digest", "md5="+base64.StdEncoding.EncodeToString(sum[:]))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Write(buf.Bytes())
+}
+
+var flaky = struct {
+	sync.Mutex
+	ranges map[string][]string
+	cut    map[string]bool
+}{ranges: make(map[string][]string), cut: make(map[string]bool)}
+
+// flakyHandler fails the first "fail" requests with the same "id" by 503,
+// with "cut" the first GET afterwards is aborted after 1000 bytes of body.
+// Then it echoes POST bodies and serves test.gif like resumeHandler.
+func flakyHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	flaky.Lock()
+	id := query.Get("id")
+	flaky.ranges[id] = append(flaky.ranges[id], r.Header.Get("Range"))
+	hits := len(flaky.ranges[id])
+	fail, _ := strconv.Atoi(query.Get("fail"))
+	cut := query.Get("cut") != "" && hits > fail && r.Method == "GET" && !flaky.cut[id]
+	if cut {
+		flaky.cut[id] = true
+	}
+	flaky.Unlock()
+
+	if hits <= fail {
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(body)
+		return
+	}
+	if cut {
+		w = &cutWriter{ResponseWriter: w, n: 1000}
+	}
+	resumeHandler(w, r)
+}
+
+// cutWriter aborts the response after n bytes of body.
+type cutWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (c *cutWriter) Write(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.ResponseWriter.Write(p)
+	c.n -= n
+	if c.n == 0 {
+		c.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	return n, err
+}
+
+func flakyRanges(id string) []string {
+	flaky.Lock()
+	defer flaky.Unlock()
+	return flaky.ranges[id]
+}
+
+// inspectHandler reports how the request was sent.
+func inspectHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := io.Copy(ioutil.Discard, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Method", r.Method)
+	w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+	w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+	w.Header().Set("X-Body-Length", strconv.FormatInt(n, 10))
+	w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+	w.Header().Set("X-Traceparent", r.Header.Get("traceparent"))
+}
+
+// formHandler reports the parts of a multipart form as "X-File" headers
+// "field=filename:size" and "X-Field" headers "key=value".
+func formHandler(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FileName() == "" {
+			w.Header().Add("X-Field", part.FormName()+"="+string(data))
+			continue
+		}
+		w.Header().Add("X-File", fmt.Sprintf("%s=%s:%d", part.FormName(), part.FileName(), len(data)))
+	}
+	w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+}
+
+type tusTestUpload struct {
+	length   int64
+	metadata string
+	data     []byte
+	// cut aborts the first PATCH after cut bytes.
+	cut int
+	// conflict rejects the first PATCH by 409.
+	conflict bool
+	// stall accepts PATCH requests without storing their data.
+	stall   bool
+	offsets []string
+}
+
+var tusUploads = struct {
+	sync.Mutex
+	m map[string]*tusTestUpload
+}{m: make(map[string]*tusTestUpload)}
+
+// tusHandler is a tus 1.0 server with the creation, checksum (sha1) and
+// termination extensions. Uploads are created at /tus, "cut" aborts the
+// first PATCH after that many bytes.
+func tusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Tus-Resumable") != "1.0.0" {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("Tus-Resumable", "1.0.0")
+	tusUploads.Lock()
+	defer tusUploads.Unlock()
+	if r.URL.Path == "/tus" && r.Method == "POST" {
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := RandomMD5()
+		query := r.URL.Query()
+		cut, _ := strconv.Atoi(query.Get("cut"))
+		tusUploads.m[id] = &tusTestUpload{
+			length:   length,
+			metadata: r.Header.Get("Upload-Metadata"),
+			cut:      cut,
+			conflict: query.Get("conflict") != "",
+			stall:    query.Get("stall") != "",
+		}
+		if query.Get("nolocation") == "" {
+			w.Header().Set("Location", "/tus/"+id)
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	upload := tusUploads.m[strings.TrimPrefix(r.URL.Path, "/tus/")]
+	if upload == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "HEAD":
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.data)))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.length, 10))
+	case "DELETE":
+		delete(tusUploads.m, strings.TrimPrefix(r.URL.Path, "/tus/"))
+		w.WriteHeader(http.StatusNoContent)
+	case "PATCH":
+		upload.offsets = append(upload.offsets, r.Header.Get("Upload-Offset"))
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		if r.Header.Get("Upload-Offset") != strconv.Itoa(len(upload.data)) || upload.conflict {
+			upload.conflict = false
+			io.Copy(ioutil.Discard, r.Body)
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		var body io.Reader = r.Body
+		if upload.cut > 0 {
+			body = io.LimitReader(r.Body, int64(upload.cut))
+		}
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sum := r.Header.Get("Upload-Checksum"); sum != "" {
+			digest := sha1.Sum(data)
+			if sum != "sha1 "+base64.StdEncoding.EncodeToString(digest[:]) {
+				w.WriteHeader(460)
+				return
+			}
+		}
+		if !upload.stall {
+			upload.data = append(upload.data, data...)
+		}
+		if upload.cut > 0 {
+			upload.cut = 0
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("Upload-Offset", strconv.Itoa(len(upload.data)))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func tusUploadOf(uploadURL string) *tusTestUpload {
+	tusUploads.Lock()
+	defer tusUploads.Unlock()
+	return tusUploads.m[uploadURL[strings.LastIndex(uploadURL, "/")+1:]]
+}
+
+type s3TestUpload struct {
+	parts    map[string][]byte
+	attempts map[string]int
+	complete []byte
+	aborted  bool
+}
+
+var s3Uploads = struct {
+	sync.Mutex
+	m map[string]*s3TestUpload
+}{m: make(map[string]*s3TestUpload)}
+
+func s3UploadOf(id string) *s3TestUpload {
+	s3Uploads.Lock()
+	defer s3Uploads.Unlock()
+	upload := s3Uploads.m[id]
+	if upload == nil {
+		upload = &s3TestUpload{parts: make(map[string][]byte), attempts: make(map[string]int)}
+		s3Uploads.m[id] = upload
+	}
+	return upload
+}
+
+// s3Handler accepts parts of the multipart upload "upload" by PUT with
+// "part", "fail" fails the first attempts of every part by 503. POST
+// completes the upload, with "error" by an error in a 200 response, and
+// DELETE aborts it.
+func s3Handler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	upload := s3UploadOf(query.Get("upload"))
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s3Uploads.Lock()
+	defer s3Uploads.Unlock()
+	switch r.Method {
+	case "PUT":
+		part := query.Get("part")
+		upload.attempts[part]++
+		if fail, _ := strconv.Atoi(query.Get("fail")); upload.attempts[part] <= fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		upload.parts[part] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+	case "POST":
+		var complete struct {
+			Parts []struct {
+				PartNumber string
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, part := range complete.Parts {
+			data := upload.parts[part.PartNumber]
+			if part.ETag != fmt.Sprintf(`"%x"`, md5.Sum(data)) {
+				http.Error(w, "<Error><Code>InvalidPart</Code></Error>", http.StatusBadRequest)
+				return
+			}
+			upload.complete = append(upload.complete, data...)
+		}
+		if query.Get("error") != "" {
+			io.WriteString(w, "<Error><Code>InternalError</Code><Message>We encountered an internal error.</Message></Error>")
+			return
+		}
+		io.WriteString(w, "<CompleteMultipartUploadResult><ETag>\"done\"</ETag></CompleteMultipartUploadResult>")
+	case "DELETE":
+		upload.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+var testSigV4 = &SigV4{AccessKeyID: "minio", SecretAccessKey: "minio-secret", Region: "us-east-1"}
+
+var minioObjects = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: make(map[string][]byte)}
+
+// minioHandler stores and serves objects of requests signed by testSigV4,
+// the payload signing is reported as "X-Payload".
+func minioHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := verifySigV4(r)
+	if err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code><Message>"+err.Error()+"</Message></Error>")
+		return
+	}
+	w.Header().Set("X-Payload", r.Header.Get("X-Amz-Content-Sha256"))
+	minioObjects.Lock()
+	defer minioObjects.Unlock()
+	switch r.Method {
+	case "PUT":
+		minioObjects.m[r.URL.Path] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(body)))
+	case "GET", "HEAD":
+		data, ok := minioObjects.m[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", testModTime, strings.NewReader(string(data)))
+	}
+}
+
+// verifySigV4 verifies the signature of r and its payload, and returns the
+// decoded body. It follows the S3 documentation of Signature Version 4
+// without the helpers of the signer, so it does not share their bugs.
+func verifySigV4(r *http.Request) ([]byte, error) {
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	fields := make(map[string]string)
+	for _, field := range strings.Split(auth, ", ") {
+		if i := strings.Index(field, "="); i > 0 {
+			fields[field[:i]] = field[i+1:]
+		}
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != len("20060102T150405Z") {
+		return nil, fmt.Errorf("invalid X-Amz-Date %q", amzDate)
+	}
+	scope := amzDate[:8] + "/" + testSigV4.Region + "/s3/aws4_request"
+	if fields["Credential"] != testSigV4.AccessKeyID+"/"+scope {
+		return nil, fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		i := sort.SearchStrings(signed, required)
+		if !sort.StringsAreSorted(signed) || i == len(signed) || signed[i] != required {
+			return nil, fmt.Errorf("invalid signed headers %q", fields["SignedHeaders"])
+		}
+	}
+	payload := r.Header.Get("X-Amz-Content-Sha256")
+
+	var headers strings.Builder
+	for _, name := range signed {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			values := r.Header.Values(name)
+			for i, v := range values {
+				values[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(values, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	var query []string
+	for k, vs := range r.URL.Query() {
+		for _, v := range vs {
+			query = append(query, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(query)
+	canonical := r.Method + "\n" +
+		awsEscape(r.URL.Path, false) + "\n" +
+		strings.Join(query, "&") + "\n" +
+		headers.String() + "\n" +
+		fields["SignedHeaders"] + "\n" +
+		payload
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	key := awsHMAC([]byte("AWS4"+testSigV4.SecretAccessKey), amzDate[:8])
+	key = awsHMAC(key, testSigV4.Region)
+	key = awsHMAC(key, "s3")
+	key = awsHMAC(key, "aws4_request")
+	signature := hex.EncodeToString(awsHMAC(key, "AWS4-HMAC-SHA256\n"+amzDate+"\n"+scope+"\n"+hex.EncodeToString(canonicalHash[:])))
+	if signature != fields["Signature"] {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch payload {
+	case "UNSIGNED-PAYLOAD":
+		return body, nil
+	case "STREAMING-AWS4-HMAC-SHA256-PAYLOAD":
+		emptyHash := sha256.Sum256(nil)
+		var decoded []byte
+		previous := signature
+		for {
+			i := bytes.Index(body, []byte("\r\n"))
+			if i < 0 {
+				return nil, fmt.Errorf("invalid chunk")
+			}
+			var size int
+			var chunkSig string
+			if _, err := fmt.Sscanf(string(body[:i]), "%x;chunk-signature=%s", &size, &chunkSig); err != nil {
+				return nil, err
+			}
+			chunk := body[i+2 : i+2+size]
+			chunkHash := sha256.Sum256(chunk)
+			previous = hex.EncodeToString(awsHMAC(key, "AWS4-HMAC-SHA256-PAYLOAD\n"+amzDate+"\n"+scope+"\n"+
+				previous+"\n"+hex.EncodeToString(emptyHash[:])+"\n"+hex.EncodeToString(chunkHash[:])))
+			if chunkSig != previous {
+				return nil, fmt.Errorf("chunk signature mismatch")
+			}
+			decoded = append(decoded, chunk...)
+			body = body[i+2+size+2:]
+			if size == 0 {
+				if strconv.Itoa(len(decoded)) != r.Header.Get("X-Amz-Decoded-Content-Length") {
+					return nil, fmt.Errorf("decoded length mismatch")
+				}
+				return decoded, nil
+			}
+		}
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != payload {
+		return nil, fmt.Errorf("payload mismatch")
+	}
+	return body, nil
+}
+
+func awsHMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsEscape percent-encodes all bytes but A-Z, a-z, 0-9, "-", "_", "." and
+// "~", and "/" of paths.
+func awsEscape(s string, query bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			strings.IndexByte("-_.~", c) >= 0, c == '/' && !query:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// slowHandler sends part of the body and blocks until the client goes away.
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Length", "1000")
+	w.Write(make([]byte, 100))
+	w.(http.Flusher).Flush()
+	<-r.Context().Done()
+}
 func handlerStream(w http.ResponseWriter, r *http.Request) error {
 	fileName := RandomMD5()
 	dst, err := os.Create(FileServer(fileName))
@@ -120,3 +701,63 @@ func RandomMD5() string {
 func FileServer(filename string) string {
 	return "testdata/fileserver/" + filename
 }
+
+// bearerHandler accepts only the token "fresh" and reports the length of the
+// body.
+func bearerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer fresh" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	inspectHandler(w, r)
+}
+
+// digestParamPattern matches the name=value and name="value" parameters of
+// a Digest Authorization header.
+var digestParamPattern = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^\s,]*))`)
+
+// digestHandler accepts the Digest credentials user:pass of realm "test",
+// offering MD5 and SHA-256, and reports the length of the body.
+func digestHandler(w http.ResponseWriter, r *http.Request) {
+	const nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		w.Header().Add("WWW-Authenticate", `Digest realm="test", nonce="`+nonce+`", algorithm=MD5, qop="auth,auth-int", opaque="5ccc069c"`)
+		w.Header().Add("WWW-Authenticate", `Digest realm="test", nonce="`+nonce+`", algorithm=SHA-256, qop="auth", opaque="5ccc069c"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// parsed and hashed without the helpers of DigestAuth
+	params := make(map[string]string)
+	for _, m := range digestParamPattern.FindAllStringSubmatch(auth[len("Digest "):], -1) {
+		params[strings.ToLower(m[1])] = m[2] + m[3]
+	}
+	var newHash func() hash.Hash
+	switch params["algorithm"] {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	}
+	if newHash == nil || params["username"] != "user" || params["realm"] != "test" || params["nonce"] != nonce ||
+		params["uri"] != r.URL.RequestURI() || params["opaque"] != "5ccc069c" || params["qop"] != "auth" {
+		io.Copy(ioutil.Discard, r.Body)
+		http.Error(w, "invalid digest "+auth, http.StatusBadRequest)
+		return
+	}
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	ha1 := h("user:test:pass")
+	ha2 := h(r.Method + ":" + params["uri"])
+	if params["response"] != h(ha1+":"+nonce+":"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.Header().Set("X-Algorithm", params["algorithm"])
+	inspectHandler(w, r)
+}
@@ -0,0 +1,198 @@
+package httpfile
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DigestAuth returns an Authorizer answering the HTTP Digest challenges
+// (RFC 7616) of the server with username and password. It supports the MD5
+// and SHA-256 algorithms, their -sess variants and the "auth" qop.
+func DigestAuth(username, password string) Challenger {
+	return &digestAuth{username: username, password: password}
+}
+
+type digestAuth struct {
+	mu       sync.Mutex
+	username string
+	password string
+	// challenge is the last accepted challenge, nil before the first one.
+	challenge map[string]string
+	nc        int
+
+	cnonce func() string
+}
+
+// digestHashes maps the supported algorithms, by preference.
+var digestHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+func digestHash(algorithm string) func() hash.Hash {
+	algorithm = strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS")
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	for _, h := range digestHashes {
+		if h.name == algorithm {
+			return h.new
+		}
+	}
+	return nil
+}
+
+func (d *digestAuth) Authorize(request *http.Request) error {
+	d.mu.Lock()
+	c := d.challenge
+	d.nc++
+	nc := d.nc
+	d.mu.Unlock()
+	if c == nil {
+		return nil
+	}
+	newHash := digestHash(c["algorithm"])
+	h := func(s string) string {
+		sum := newHash()
+		sum.Write([]byte(s))
+		return hex.EncodeToString(sum.Sum(nil))
+	}
+	cnonceHex, err := d.newCnonce()
+	if err != nil {
+		return err
+	}
+	ncHex := fmt.Sprintf("%08x", nc)
+	uri := request.URL.RequestURI()
+	ha1 := h(d.username + ":" + c["realm"] + ":" + d.password)
+	if strings.HasSuffix(strings.ToUpper(c["algorithm"]), "-SESS") {
+		ha1 = h(ha1 + ":" + c["nonce"] + ":" + cnonceHex)
+	}
+	ha2 := h(request.Method + ":" + uri)
+	params := []string{
+		fmt.Sprintf(`username="%s"`, escapeQuotes(d.username)),
+		fmt.Sprintf(`realm="%s"`, escapeQuotes(c["realm"])),
+		fmt.Sprintf(`nonce="%s"`, escapeQuotes(c["nonce"])),
+		fmt.Sprintf(`uri="%s"`, escapeQuotes(uri)),
+	}
+	if c["algorithm"] != "" {
+		params = append(params, "algorithm="+c["algorithm"])
+	}
+	if c["qop"] == "" {
+		params = append(params, fmt.Sprintf(`response="%s"`, h(ha1+":"+c["nonce"]+":"+ha2)))
+	} else {
+		response := h(strings.Join([]string{ha1, c["nonce"], ncHex, cnonceHex, "auth", ha2}, ":"))
+		params = append(params, "qop=auth", "nc="+ncHex, fmt.Sprintf(`cnonce="%s"`, cnonceHex), fmt.Sprintf(`response="%s"`, response))
+	}
+	if opaque, ok := c["opaque"]; ok {
+		params = append(params, fmt.Sprintf(`opaque="%s"`, escapeQuotes(opaque)))
+	}
+	request.Header.Set("Authorization", "Digest "+strings.Join(params, ", "))
+	return nil
+}
+
+func (d *digestAuth) newCnonce() (string, error) {
+	if d.cnonce != nil {
+		return d.cnonce(), nil
+	}
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(cnonce), nil
+}
+
+// Challenge accepts the most preferred supported challenge of resp. The
+// request is not sent again if the challenge is the one that was answered,
+// unless its nonce is stale.
+func (d *digestAuth) Challenge(resp *http.Response) bool {
+	var best map[string]string
+	bestRank := len(digestHashes)
+	for _, v := range resp.Header.Values("WWW-Authenticate") {
+		if len(v) < 7 || !strings.EqualFold(v[:7], "Digest ") {
+			continue
+		}
+		c := parseAuthParams(v[7:])
+		if qop, ok := c["qop"]; ok && !containsToken(qop, "auth") {
+			continue
+		}
+		if c["nonce"] == "" {
+			continue
+		}
+		algorithm := strings.TrimSuffix(strings.ToUpper(c["algorithm"]), "-SESS")
+		if algorithm == "" {
+			algorithm = "MD5"
+		}
+		for rank, h := range digestHashes {
+			if h.name == algorithm && rank < bestRank {
+				best, bestRank = c, rank
+			}
+		}
+	}
+	if best == nil {
+		return false
+	}
+	if _, ok := best["qop"]; ok {
+		best["qop"] = "auth"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.challenge != nil && d.challenge["nonce"] == best["nonce"] && !strings.EqualFold(best["stale"], "true") {
+		return false
+	}
+	d.challenge = best
+	d.nc = 0
+	return true
+}
+
+// parseAuthParams parses the comma separated auth-params of a challenge,
+// keys are lowercased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " ")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				end = len(s) - 1
+			}
+			value, s = unquote(s[:end+1]), s[end+1:]
+		} else if j := strings.IndexByte(s, ','); j >= 0 {
+			value, s = strings.TrimSpace(s[:j]), s[j:]
+		} else {
+			value, s = strings.TrimSpace(s), ""
+		}
+		params[key] = value
+	}
+}
+
+func containsToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package httpfile
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// maxErrorBodySize limits the response body kept in an HTTPError.
+const maxErrorBodySize = 4 << 10
+
+// HTTPError is returned when the status code of a response is rejected by
+// the success policy, use errors.As to inspect it.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	// URL is the request URL, its password and the credentials in its
+	// query, such as the signature of a presigned URL, are redacted.
+	URL    string
+	Method string
+	// Body is the beginning of the response body, at most 4KB.
+	Body []byte
+}
+
+func (e *HTTPError) Error() string {
+	msg := e.Status
+	if e.Method != "" {
+		msg = e.Method + " " + e.URL + ": " + msg
+	}
+	if len(e.Body) > 0 {
+		msg += ": " + string(e.Body)
+	}
+	return msg
+}
+
+// newHTTPError reads the beginning of resp.Body, the body remains readable
+// from the start.
+func newHTTPError(resp *http.Response) *HTTPError {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	resp.Body = &multiReadCloser{
+		Reader: io.MultiReader(bytes.NewReader(body), resp.Body),
+		Closer: resp.Body,
+	}
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     resp.Header,
+		Body:       body,
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		e.URL = redactURL(resp.Request.URL.String())
+	}
+	return e
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// checkStatus returns the success policy to use, 2xx by default.
+func checkStatus(success func(statusCode int) bool) func(statusCode int) bool {
+	if success == nil {
+		return isSuccess
+	}
+	return success
+}
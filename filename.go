@@ -0,0 +1,216 @@
+package httpfile
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mushroomsir/mimetypes"
+)
+
+// maxFileNameSize is the common limit of file names in bytes.
+const maxFileNameSize = 255
+
+// dispositionFileName returns the file name of a Content-Disposition header
+// (RFC 6266), the RFC 5987 encoded filename* is preferred over filename.
+// The name is not sanitized.
+func dispositionFileName(header string) string {
+	var name, extName string
+	params := splitParams(header)
+	if len(params) == 0 {
+		return ""
+	}
+	for _, param := range params[1:] {
+		i := strings.IndexByte(param, '=')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(param[:i]))
+		value := strings.TrimSpace(param[i+1:])
+		switch key {
+		case "filename*":
+			if v, ok := decodeExtValue(value); ok {
+				extName = v
+			}
+		case "filename":
+			name = unquote(value)
+		}
+	}
+	if extName != "" {
+		return extName
+	}
+	return name
+}
+
+// splitParams splits s by semicolons outside of quoted strings.
+func splitParams(s string) []string {
+	var params []string
+	var quoted, escaped bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == ';':
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(params, s[start:])
+}
+
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	s = s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// decodeExtValue decodes charset'language'value of RFC 5987, UTF-8 and
+// ISO-8859-1 are supported.
+func decodeExtValue(s string) (string, bool) {
+	parts := strings.SplitN(s, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	value, err := url.PathUnescape(parts[2])
+	if err != nil {
+		return "", false
+	}
+	switch strings.ToLower(parts[0]) {
+	case "utf-8":
+		if !utf8.ValidString(value) {
+			return "", false
+		}
+		return value, true
+	case "iso-8859-1":
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		return string(runes), true
+	}
+	return "", false
+}
+
+// reservedNames can not be used as file names on Windows, with any
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFileName makes a file name sent by a server safe to create in a
+// directory: directories, control characters, characters reserved on
+// Windows, leading dots and reserved names are removed or replaced. It
+// returns "" if nothing is left.
+func sanitizeFileName(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, ". ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return ""
+	}
+	base := name
+	if i := strings.IndexByte(base, '.'); i >= 0 {
+		base = base[:i]
+	}
+	if reservedNames[strings.ToUpper(strings.TrimSpace(base))] {
+		name = "_" + name
+	}
+	if len(name) > maxFileNameSize {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameSize/2 {
+			ext = ""
+		}
+		base := name[:maxFileNameSize-len(ext)]
+		for !utf8.ValidString(base) {
+			base = base[:len(base)-1]
+		}
+		name = base + ext
+	}
+	return name
+}
+
+// responseFileName returns the sanitized file name of the Content-Disposition
+// of resp, or "" if there is none.
+func responseFileName(resp *http.Response) string {
+	return sanitizeFileName(dispositionFileName(resp.Header.Get("Content-Disposition")))
+}
+
+// downloadPath returns the path in dir to save resp to when no path is
+// given. The name is taken from Content-Disposition, then the path of the
+// final URL after redirects, with the extension of Content-Type if it has
+// none.
+func downloadPath(dir string, resp *http.Response) string {
+	name := responseFileName(resp)
+	if name == "" {
+		if resp.Request != nil && resp.Request.URL != nil {
+			name = sanitizeFileName(path.Base(resp.Request.URL.Path))
+		}
+		if name == "" {
+			name = "unknown"
+		}
+		if filepath.Ext(name) == "" {
+			name += contentTypeExtension(resp.Header.Get("Content-Type"))
+		}
+	}
+	return filepath.Join(dir, name)
+}
+
+// contentTypeExtension returns the extension of a Content-Type, preferring
+// one that mimetypes maps back to it, then the one named like the subtype.
+func contentTypeExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	exts, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(exts) == 0 {
+		return ""
+	}
+	best, bestScore := "", -1
+	for _, ext := range exts {
+		score := 0
+		if mimetypes.Lookup("file"+ext) == mediaType {
+			score += 2
+		}
+		if strings.HasSuffix(mediaType, "/"+ext[1:]) {
+			score++
+		}
+		if score > bestScore || score == bestScore && len(ext) < len(best) {
+			best, bestScore = ext, score
+		}
+	}
+	return best
+}
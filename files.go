@@ -1,14 +1,16 @@
 package httpfile
 
 import (
-	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
-	"mime"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/mushroomsir/mimetypes"
 )
@@ -20,10 +22,38 @@ var (
 
 // Files ...
 type Files struct {
-	client    *http.Client
-	targetURL string
-	filePath  string
-	header    map[string]string
+	ctx               context.Context
+	client            *http.Client
+	targetURL         string
+	filePath          string
+	header            map[string]string
+	resume            bool
+	segments          int
+	success           func(statusCode int) bool
+	retry             RetryPolicy
+	progress          func(Progress)
+	interval          time.Duration
+	checksum          Checksum
+	skipServerDigests bool
+	dir               string
+	collision         CollisionPolicy
+	conditional       bool
+	rateLimit         int64
+	limiter           *Limiter
+	form              []*formPart
+	method            string
+	tusStore          TusStore
+	tusChunkSize      int64
+	tusMetadata       map[string]string
+	tusChecksum       string
+	partSize          int64
+	partURLs          []string
+	partURLFunc       func(partNumber int) (string, error)
+	partConcurrency   int
+	abortURL          string
+	auth              Authorizer
+	middleware        []Middleware
+	observers
 }
 
 // NewReq ...
@@ -33,10 +63,12 @@ func NewReq(targetURL string, filePath ...string) *Files {
 		fp = filePath[0]
 	}
 	hf := &Files{
+		ctx:       context.Background(),
 		client:    defaultHTTPClient,
 		targetURL: targetURL,
 		filePath:  fp,
 		header:    make(map[string]string),
+		method:    http.MethodPost,
 	}
 	return hf
 }
@@ -49,12 +81,293 @@ func (h *Files) SetHTTPClient(c *http.Client) *Files {
 	return h
 }
 
+// SetContext sets the context of the requests, canceling it aborts the
+// transfer and removes the partial download.
+func (h *Files) SetContext(ctx context.Context) *Files {
+	if ctx != nil {
+		h.ctx = ctx
+	}
+	return h
+}
+
 // SetHeader ...
 func (h *Files) SetHeader(k, v string) *Files {
 	h.header[k] = v
 	return h
 }
 
+// SetResume makes Download continue a partial file at filePath by a Range
+// request instead of starting over.
+func (h *Files) SetResume(resume bool) *Files {
+	h.resume = resume
+	return h
+}
+
+// SetSegments makes Download fetch the file in n concurrent byte ranges
+// when the server advertises range support.
+func (h *Files) SetSegments(n int) *Files {
+	h.segments = n
+	return h
+}
+
+// SetSuccessPolicy sets which status codes are accepted by Download, 2xx by
+// default. Other responses are returned as *HTTPError.
+func (h *Files) SetSuccessPolicy(fn func(statusCode int) bool) *Files {
+	h.success = fn
+	return h
+}
+
+// SetRetryPolicy sets the policy to retry failed requests, none by default.
+func (h *Files) SetRetryPolicy(p RetryPolicy) *Files {
+	h.retry = p
+	return h
+}
+
+// SetProgress sets a callback reporting the progress of Upload,
+// UploadByStream and Download.
+func (h *Files) SetProgress(fn func(Progress)) *Files {
+	h.progress = fn
+	return h
+}
+
+// SetProgressInterval sets the minimum interval between two progress
+// reports, DefaultProgressInterval by default.
+func (h *Files) SetProgressInterval(d time.Duration) *Files {
+	h.interval = d
+	return h
+}
+
+// SetChecksum sets the expected digest of Download, the file is removed if
+// it does not match. Digests advertised by the server are verified as well,
+// see SetServerDigests.
+func (h *Files) SetChecksum(algorithm, hexDigest string) *Files {
+	h.checksum = Checksum{Algorithm: algorithm, Hex: hexDigest}
+	return h
+}
+
+// SetServerDigests sets whether Download verifies the digests advertised by
+// the server in Repr-Digest, Digest, Content-MD5 and an ETag that is a hex
+// MD5, true by default. Disable it for servers whose ETag is not the MD5 of
+// the content, such as S3 objects encrypted by SSE-KMS or SSE-C.
+func (h *Files) SetServerDigests(verify bool) *Files {
+	h.skipServerDigests = !verify
+	return h
+}
+
+// SetDownloadDir sets the directory of Download when the file name comes from
+// the Content-Disposition of the response. The name is sanitized, so it can
+// not escape dir.
+func (h *Files) SetDownloadDir(dir string) *Files {
+	h.dir = dir
+	return h
+}
+
+// SetCollisionPolicy sets what happens when the file name taken from the
+// response already exists, CollisionOverwrite by default. It does not apply
+// to a path given to NewReq.
+func (h *Files) SetCollisionPolicy(p CollisionPolicy) *Files {
+	h.collision = p
+	return h
+}
+
+// SetConditional makes Download store the ETag and Last-Modified of the
+// response in filePath+".meta", and send them as If-None-Match and
+// If-Modified-Since on the next call. A 304 Not Modified leaves the file
+// untouched and is not an error, see Response.Refreshed. It requires the
+// path given to NewReq.
+func (h *Files) SetConditional(conditional bool) *Files {
+	h.conditional = conditional
+	return h
+}
+
+// SetRateLimit caps the bytes per second of each transfer, 0 for no limit.
+// The segments of a download share the limit.
+func (h *Files) SetRateLimit(bytesPerSecond int64) *Files {
+	h.rateLimit = bytesPerSecond
+	return h
+}
+
+// SetLimiter caps the bytes per second of all transfers sharing l, in
+// addition to SetRateLimit.
+func (h *Files) SetLimiter(l *Limiter) *Files {
+	h.limiter = l
+	return h
+}
+
+// SetMethod sets the method of Upload and UploadByStream, POST by default.
+func (h *Files) SetMethod(method string) *Files {
+	if method != "" {
+		h.method = method
+	}
+	return h
+}
+
+// SetTusStore sets the store of UploadTus to continue uploads of another
+// process, uploads are only resumed within one call by default.
+func (h *Files) SetTusStore(store TusStore) *Files {
+	h.tusStore = store
+	return h
+}
+
+// SetTusChunkSize sets the maximum bytes of one PATCH request of UploadTus,
+// the whole file by default.
+func (h *Files) SetTusChunkSize(n int64) *Files {
+	h.tusChunkSize = n
+	return h
+}
+
+// SetTusMetadata adds Upload-Metadata to UploadTus, in addition to
+// "filename" and "filetype".
+func (h *Files) SetTusMetadata(k, v string) *Files {
+	if h.tusMetadata == nil {
+		h.tusMetadata = make(map[string]string)
+	}
+	h.tusMetadata[k] = v
+	return h
+}
+
+// SetTusChecksum makes UploadTus send an Upload-Checksum of algorithm with
+// every chunk, the server must support the checksum extension.
+func (h *Files) SetTusChecksum(algorithm string) *Files {
+	h.tusChecksum = algorithm
+	return h
+}
+
+// SetPartSize sets the size of the parts of UploadMultipart, but the last,
+// DefaultPartSize by default.
+func (h *Files) SetPartSize(n int64) *Files {
+	h.partSize = n
+	return h
+}
+
+// SetPartURLs sets the presigned URLs of the parts of UploadMultipart, in the
+// order of the part numbers.
+func (h *Files) SetPartURLs(urls ...string) *Files {
+	h.partURLs = urls
+	return h
+}
+
+// SetPartURLFunc sets a function returning the presigned URL of a part of
+// UploadMultipart by its number starting at 1, instead of SetPartURLs.
+func (h *Files) SetPartURLFunc(fn func(partNumber int) (string, error)) *Files {
+	h.partURLFunc = fn
+	return h
+}
+
+// SetPartConcurrency sets how many parts UploadMultipart uploads at a time,
+// DefaultPartConcurrency by default.
+func (h *Files) SetPartConcurrency(n int) *Files {
+	h.partConcurrency = n
+	return h
+}
+
+// SetAbortURL sets the presigned URL to abort a failed UploadMultipart by
+// DELETE.
+func (h *Files) SetAbortURL(abortURL string) *Files {
+	h.abortURL = abortURL
+	return h
+}
+
+// AddFile adds the file at path to the form of Upload as fieldName, its
+// Content-Type is looked up by the extension.
+func (h *Files) AddFile(fieldName, path string) *Files {
+	fileName := filepath.Base(path)
+	h.form = append(h.form, &formPart{
+		fieldName:   fieldName,
+		fileName:    fileName,
+		contentType: fileContentType(fileName),
+		filePath:    path,
+	})
+	return h
+}
+
+// AddReader adds the content of r to the form of Upload as fieldName. The
+// upload is only retried and sent with a Content-Length if r is an
+// io.Seeker. The Content-Type is looked up by fileName if empty.
+func (h *Files) AddReader(fieldName, fileName, contentType string, r io.Reader) *Files {
+	if contentType == "" {
+		contentType = fileContentType(fileName)
+	}
+	h.form = append(h.form, newReaderPart(fieldName, fileName, contentType, r))
+	return h
+}
+
+// AddField adds a value to the form of Upload.
+func (h *Files) AddField(k, v string) *Files {
+	h.form = append(h.form, &formPart{fieldName: k, value: v})
+	return h
+}
+
+// SetAuthorizer sets an Authorizer, such as *SigV4, called for every request
+// right before it is sent.
+func (h *Files) SetAuthorizer(a Authorizer) *Files {
+	h.auth = a
+	return h
+}
+
+// SetBasicAuth authorizes requests by the Basic scheme.
+func (h *Files) SetBasicAuth(username, password string) *Files {
+	return h.SetAuthorizer(BasicAuth(username, password))
+}
+
+// SetTokenSource authorizes requests by the bearer tokens of src, a 401
+// response refreshes the token once.
+func (h *Files) SetTokenSource(src TokenSource) *Files {
+	return h.SetAuthorizer(BearerAuth(src))
+}
+
+// SetDigestAuth authorizes requests by the Digest scheme, answering the
+// challenge of the server.
+func (h *Files) SetDigestAuth(username, password string) *Files {
+	return h.SetAuthorizer(DigestAuth(username, password))
+}
+
+// Use adds middleware wrapping every request, see Middleware.
+func (h *Files) Use(middleware ...Middleware) *Files {
+	h.middleware = append(h.middleware, middleware...)
+	return h
+}
+
+// OnBeforeRequest calls fn before every request is sent, an error aborts
+// the request.
+func (h *Files) OnBeforeRequest(fn func(request *http.Request) error) *Files {
+	return h.Use(BeforeRequest(fn))
+}
+
+// OnAfterResponse calls fn with every response, an error is returned
+// instead of the response.
+func (h *Files) OnAfterResponse(fn func(resp *http.Response) error) *Files {
+	return h.Use(AfterResponse(fn))
+}
+
+// httpClient returns the client sending requests through the middleware
+// and authorizing them by the Authorizer.
+func (h *Files) httpClient() *http.Client {
+	return wrapClient(h.client, h.auth, h.middleware)
+}
+
+// SetLogger sets a logger receiving one record per transfer with its
+// method, URL, status, bytes, duration, retries, file, checksum and error.
+// Credentials in the URL and the headers are redacted.
+func (h *Files) SetLogger(logger *slog.Logger) *Files {
+	h.logger = logger
+	return h
+}
+
+// SetMetrics sets a collector receiving the metrics of every transfer, such
+// as *Metrics.
+func (h *Files) SetMetrics(m MetricsCollector) *Files {
+	h.metrics = m
+	return h
+}
+
+// SetTracer sets a tracer receiving a span per transfer, the requests carry
+// the W3C traceparent header of their span.
+func (h *Files) SetTracer(tracer Tracer) *Files {
+	h.tracer = tracer
+	return h
+}
+
 // SetAuthorization ...
 func (h *Files) SetAuthorization(v string) *Files {
 	h.header["Authorization"] = v
@@ -67,7 +380,7 @@ func (h *Files) checkUpload() *Response {
 		res.err = ErrEmptyTargetURL
 		return res
 	}
-	if h.filePath == "" {
+	if h.filePath == "" && len(h.form) == 0 {
 		res.err = ErrEmptyFilePath
 		return res
 	}
@@ -76,73 +389,123 @@ func (h *Files) checkUpload() *Response {
 	return res
 }
 
-// Upload upload file by FormData
-func (h *Files) Upload() *Response {
-	res := h.checkUpload()
-	if res.err != nil {
-		return res
-	}
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
-
-	flieNames := strings.Split(h.filePath, "/")
-	fileName := flieNames[len(flieNames)-1]
-	var fileWriter io.Writer
+// fileContentType returns the Content-Type of fileName by its extension.
+func fileContentType(fileName string) string {
 	contentType := mimetypes.Lookup(fileName)
 	if contentType == "" {
 		contentType = "application/octet-stream"
 	}
-	fileWriter, err := createFormFile(bodyWriter, fileName, contentType)
-	if err != nil {
-		res.err = err
+	return contentType
+}
+
+// Upload upload file by FormData as "file", followed by the parts added by
+// AddFile, AddReader and AddField. The body is streamed with a known
+// Content-Length unless a reader of unknown length is added.
+func (h *Files) Upload() *Response {
+	return h.transfer("Upload", h.method, h.upload)
+}
+
+func (h *Files) upload() *Response {
+	res := h.checkUpload()
+	if res.err != nil {
 		return res
 	}
-	fh, err := os.Open(h.filePath)
-	if err != nil {
-		res.err = err
-		return res
+	body := newMultipartBody()
+	if h.filePath != "" {
+		flieNames := strings.Split(h.filePath, "/")
+		fileName := flieNames[len(flieNames)-1]
+		part, err := newFilePart("file", h.filePath, fileName, fileContentType(fileName))
+		if err != nil {
+			res.err = err
+			return res
+		}
+		body.addFile(part)
 	}
-	_, res.err = io.Copy(fileWriter, fh)
-	fh.Close()
-	if err != nil {
-		return res
+	for _, part := range h.form {
+		if !part.isFile() {
+			body.addField(part.fieldName, part.value)
+			continue
+		}
+		if part.reader == nil {
+			var err error
+			if part, err = newFilePart(part.fieldName, part.filePath, part.fileName, part.contentType); err != nil {
+				res.err = err
+				return res
+			}
+		}
+		body.addFile(part)
 	}
-	bodyWriter.Close()
-	request, err := http.NewRequest(http.MethodPost, h.targetURL, bodyBuf)
-	if err != nil {
-		res.err = err
-		return res
+	size := body.Size()
+	p := newProgress(h.progress, h.interval)
+	t := newThrottle(h.rateLimit, h.limiter)
+	retry := newRetrier(h.ctx, h.retry)
+	if !body.rewindable() {
+		retry.policy = nil
 	}
-	request.Header.Set("Content-Type", bodyWriter.FormDataContentType())
-	for k, v := range h.header {
-		request.Header.Set(k, v)
+	res.resp, res.err = retry.do(h.httpClient(), func() (*http.Request, error) {
+		reader := body.Reader(h.ctx)
+		p.reset(0, size)
+		request, err := h.newRequest(h.method, p.reader(t.reader(h.ctx, countSent(h.ctx, reader))), body.ContentType())
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		if size >= 0 {
+			request.ContentLength = size
+		}
+		return request, nil
+	})
+	if res.err == nil {
+		p.done()
 	}
-	res.resp, res.err = h.client.Do(request)
 	return res
 }
 
-// UploadByStream upload by stream
+// UploadByStream upload by stream, the file is the raw body with its
+// Content-Length and a Content-Type looked up by the extension.
 func (h *Files) UploadByStream() *Response {
+	return h.transfer("UploadByStream", h.method, h.uploadByStream)
+}
+
+func (h *Files) uploadByStream() *Response {
 	res := h.checkUpload()
-	if res.err != nil {
-		return res
-	}
-	file, err := os.Open(h.filePath)
-	if err != nil {
-		res.err = err
-		return res
+	if res.err == nil && h.filePath == "" {
+		res.err = ErrEmptyFilePath
 	}
-	defer file.Close()
-	request, err := http.NewRequest(http.MethodPost, h.targetURL, file)
-	if err != nil {
-		res.err = err
+	if res.err != nil {
 		return res
 	}
-	request.Header.Set("Content-Type", "binary/octet-stream")
-	for k, v := range h.header {
-		request.Header.Set(k, v)
+	p := newProgress(h.progress, h.interval)
+	t := newThrottle(h.rateLimit, h.limiter)
+	// the file is reopened for every attempt, the transport closes it
+	res.resp, res.err = newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		file, err := os.Open(h.filePath)
+		if err != nil {
+			return nil, err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		p.reset(0, stat.Size())
+		var body io.Reader = http.NoBody
+		if stat.Size() > 0 {
+			body = p.reader(t.reader(h.ctx, countSent(h.ctx, file)))
+		} else {
+			file.Close()
+		}
+		request, err := h.newRequest(h.method, body, fileContentType(filepath.Base(h.filePath)))
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		request.ContentLength = stat.Size()
+		return request, nil
+	})
+	if res.err == nil {
+		p.done()
 	}
-	res.resp, res.err = h.client.Do(request)
 	return res
 }
 
@@ -156,81 +519,278 @@ func (h *Files) checkDownload() *Response {
 	return res
 }
 
-// Download will get filename from 'Content-Disposition' if savePath is empty.
+// Download will get filename from 'Content-Disposition' if savePath is empty,
+// the name is sanitized against path traversal. The file is written to a
+// temporary file and renamed into place only when the body is read
+// completely and its checksums match. Responses rejected by the success
+// policy are returned as *HTTPError without touching the file. With a retry
+// policy an interrupted body is continued by a Range request when possible.
 func (h *Files) Download() *Response {
+	return h.transfer("Download", http.MethodGet, h.download)
+}
+
+func (h *Files) download() *Response {
 	res := h.checkDownload()
 	if res.err != nil {
 		return res
 	}
-	request, err := http.NewRequest(http.MethodGet, h.targetURL, nil)
-	if err != nil {
-		res.err = err
-		return res
+	var cache *fileMeta
+	if h.conditional {
+		cache = cachedMeta(h.filePath)
 	}
-	for k, v := range h.header {
-		request.Header.Set(k, v)
+	if h.segments > 1 {
+		if res := h.downloadSegments(cache); res != nil {
+			return res
+		}
 	}
-	res.resp, res.err = h.client.Do(request)
-	if res.err != nil {
-		return res
+	var out *downloadFile
+	var v *verifier
+	var started bool
+	var offset int64
+	var validator string
+	if h.resume && h.filePath != "" {
+		offset, validator = resumeOffset(h.filePath)
 	}
-	if h.filePath == "" {
-		_, params, err := mime.ParseMediaType(res.resp.Header.Get("Content-Disposition"))
-		if err == nil {
-			h.filePath = params["filename"]
+	p := newProgress(h.progress, h.interval)
+	t := newThrottle(h.rateLimit, h.limiter)
+	retry := newRetrier(h.ctx, h.retry)
+	for {
+		res.resp, res.err = retry.do(h.httpClient(), func() (*http.Request, error) {
+			request, err := h.newRequest(http.MethodGet, nil, "")
+			if err == nil && offset > 0 && validator != "" {
+				request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+				request.Header.Set("If-Range", validator)
+			} else if err == nil && !started {
+				cache.setConditional(request)
+			}
+			return request, err
+		})
+		if res.err != nil {
+			break
+		}
+		if cache != nil && !started && res.resp.StatusCode == http.StatusNotModified {
+			res.resp.Body.Close()
+			return res
+		}
+		if offset > 0 && res.resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			// The partial file is stale or already complete, start over.
+			res.resp.Body.Close()
+			offset = 0
+			continue
+		}
+		if !checkStatus(h.success)(res.resp.StatusCode) {
+			res.err = newHTTPError(res.resp)
+			res.resp.Body.Close()
+			break
+		}
+		res.accepted = true
+		partial := offset > 0 && res.resp.StatusCode == http.StatusPartialContent
+		if partial {
+			first, _, err := parseContentRange(res.resp.Header.Get("Content-Range"))
+			if err == nil && first != offset {
+				err = ErrRangeMismatch
+			}
+			if err != nil {
+				res.resp.Body.Close()
+				res.err = err
+				break
+			}
 		} else {
-			h.filePath = "unknown"
+			offset = 0
+			validator = newFileMeta(res.resp.Header).validator()
+		}
+		// a continued body is hashed by the verifier of the previous attempt
+		if !partial || !started {
+			if v, res.err = newVerifier(h.checksum, res.resp, !partial, !h.skipServerDigests); res.err != nil {
+				res.resp.Body.Close()
+				break
+			}
+		}
+		if res.err = h.prepareDownloadFile(res, &out, offset); res.err != nil {
+			res.resp.Body.Close()
+			if res.err == errSkipped {
+				res.err = nil
+			}
+			break
+		}
+		if partial && !started {
+			if res.err = v.hashFile(partPath(h.filePath), offset); res.err != nil {
+				res.resp.Body.Close()
+				break
+			}
+		}
+		started = true
+		p.reset(offset, contentTotal(offset, res.resp))
+		n, err := io.Copy(v.wrap(out), p.reader(t.reader(h.ctx, countReceived(h.ctx, res.resp.Body))))
+		res.resp.Body.Close()
+		offset += n
+		if err == nil {
+			res.digests = v.digests()
+			if res.err = v.verify(); res.err != nil {
+				// a corrupted partial file can not be resumed
+				out.keep = false
+				os.Remove(resumeMetaPath(h.filePath))
+				break
+			}
+			if h.commit(res, out) {
+				p.done()
+			}
+			if h.resume && res.err == nil {
+				os.Remove(resumeMetaPath(h.filePath))
+			}
+			return res
+		}
+		ok, waitErr := retry.retry(nil, err)
+		if !ok {
+			res.err = err
+			if waitErr != nil {
+				res.err = waitErr
+			}
+			break
 		}
-		res.filePath = h.filePath
 	}
-	out, err := os.Create(h.filePath)
-	if err != nil {
-		res.err = err
-		return res
+	if out != nil {
+		out.Abort()
 	}
-	_, res.err = io.Copy(out, res.resp.Body)
-	out.Sync()
-	out.Close()
-	res.resp.Body.Close()
 	return res
 }
 
+// prepareDownloadFile opens the destination on the first response, and
+// truncates it when a response starts from the beginning.
+func (h *Files) prepareDownloadFile(res *Response, out **downloadFile, offset int64) (err error) {
+	if *out != nil {
+		if offset > 0 {
+			return nil
+		}
+		if err = (*out).Truncate(0); err == nil {
+			_, err = (*out).Seek(0, io.SeekStart)
+		}
+	} else {
+		named := h.filePath == ""
+		if named {
+			if err = h.nameDownload(res); err != nil {
+				return err
+			}
+		}
+		if h.resume {
+			*out, err = openPartFile(h.filePath, offset > 0)
+		} else {
+			*out, err = createDownloadFile(h.filePath)
+		}
+		if err == nil && named {
+			(*out).collision = h.collision
+		}
+	}
+	if err == nil && h.resume && offset == 0 {
+		err = writeFileMeta(resumeMetaPath(h.filePath), newFileMeta(res.resp.Header))
+	}
+	return err
+}
+
+// errSkipped stops a download skipped by CollisionSkip.
+var errSkipped = errors.New("skipped")
+
+// nameDownload sets the path of a download from the response, an existing
+// file is checked early to save the transfer.
+func (h *Files) nameDownload(res *Response) error {
+	h.filePath = downloadPath(h.dir, res.resp)
+	res.filePath = h.filePath
+	if h.collision != CollisionSkip && h.collision != CollisionFail {
+		return nil
+	}
+	if _, err := os.Stat(h.filePath); err != nil {
+		return nil
+	}
+	if h.collision == CollisionSkip {
+		res.skipped = true
+		return errSkipped
+	}
+	return ErrFileExists
+}
+
+// commit renames out into place, a download skipped by the collision
+// policy on Commit is not an error.
+func (h *Files) commit(res *Response, out *downloadFile) bool {
+	res.err = out.Commit()
+	if res.err == errSkipped {
+		res.skipped = true
+		res.err = nil
+		return false
+	}
+	if res.err == nil {
+		h.committed(res, out.path)
+	}
+	return res.err == nil
+}
+
+// committed records a download committed to path.
+func (h *Files) committed(res *Response, path string) {
+	res.filePath = path
+	res.refreshed = true
+	if h.conditional {
+		res.err = storeCacheMeta(path, res.resp.Header)
+	}
+}
+
+// DownloadToDir downloads into dir, the file name is taken from the
+// Content-Disposition, the final URL or the Content-Type of the response.
+// Existing files are handled by the collision policy.
+func (h *Files) DownloadToDir(dir string) *Response {
+	h.dir = dir
+	h.filePath = ""
+	return h.Download()
+}
+
 // Head ...
 func (h *Files) Head() *Response {
+	return h.transfer("Head", http.MethodHead, h.head)
+}
+
+func (h *Files) head() *Response {
 	res := h.checkDownload()
 	if res.err != nil {
 		return res
 	}
-	request, err := http.NewRequest(http.MethodHead, h.targetURL, nil)
-	if err != nil {
-		res.err = err
-		return res
-	}
-	for k, v := range h.header {
-		request.Header.Set(k, v)
-	}
-	res.resp, res.err = h.client.Do(request)
+	res.resp, res.err = newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		return h.newRequest(http.MethodHead, nil, "")
+	})
 	return res
 }
 
 // Get will get response from target URL.
 func (h *Files) Get() *Response {
+	return h.transfer("Get", http.MethodGet, h.get)
+}
+
+func (h *Files) get() *Response {
 	res := h.checkDownload()
 	if res.err != nil {
 		return res
 	}
-	request, err := http.NewRequest(http.MethodGet, h.targetURL, nil)
-	if err != nil {
-		res.err = err
+	res.resp, res.err = newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		return h.newRequest(http.MethodGet, nil, "")
+	})
+	if res.err != nil {
 		return res
 	}
+	if name := responseFileName(res.resp); name != "" {
+		res.filePath = name
+	}
+	return res
+}
+
+// newRequest creates a request to the target URL with the custom headers,
+// which override contentType.
+func (h *Files) newRequest(method string, body io.Reader, contentType string) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(h.ctx, method, h.targetURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
 	for k, v := range h.header {
 		request.Header.Set(k, v)
 	}
-	res.resp, res.err = h.client.Do(request)
-	_, params, err := mime.ParseMediaType(res.resp.Header.Get("Content-Disposition"))
-	if err == nil {
-		res.filePath = params["filename"]
-	}
-	return res
+	return request, nil
 }
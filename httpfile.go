@@ -1,15 +1,15 @@
 package httpfile
 
 import (
-	"bytes"
-	"errors"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
-	"mime"
-	"mime/multipart"
+	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 var httpFile = New(nil)
@@ -25,51 +25,224 @@ func New(client *http.Client) *HTTPFile {
 
 // HTTPFile ...
 type HTTPFile struct {
-	client *http.Client
+	client            *http.Client
+	success           func(statusCode int) bool
+	skipServerDigests bool
+	retry             RetryPolicy
+	progress          func(Progress)
+	interval          time.Duration
+	dir               string
+	rateLimit         int64
+	limiter           *Limiter
+	method            string
+	auth              Authorizer
+	middleware        []Middleware
+	observers
 }
 
-// Upload ...
+// SetSuccessPolicy sets which status codes are accepted by Download, 2xx by
+// default. Other responses are returned as *HTTPError.
+func (h *HTTPFile) SetSuccessPolicy(fn func(statusCode int) bool) *HTTPFile {
+	h.success = fn
+	return h
+}
+
+// SetServerDigests sets whether Download verifies the digests advertised by
+// the server in Repr-Digest, Digest, Content-MD5 and an ETag that is a hex
+// MD5, true by default. Disable it for servers whose ETag is not the MD5 of
+// the content, such as S3 objects encrypted by SSE-KMS or SSE-C.
+func (h *HTTPFile) SetServerDigests(verify bool) *HTTPFile {
+	h.skipServerDigests = !verify
+	return h
+}
+
+// SetRetryPolicy sets the policy to retry failed requests, none by default.
+// UploadReader only retries bodies implementing io.Seeker.
+func (h *HTTPFile) SetRetryPolicy(p RetryPolicy) *HTTPFile {
+	h.retry = p
+	return h
+}
+
+// SetProgress sets a callback reporting the progress of transfers, Upload
+// uses UploadOptions.Progress instead if it is set.
+func (h *HTTPFile) SetProgress(fn func(Progress)) *HTTPFile {
+	h.progress = fn
+	return h
+}
+
+// SetProgressInterval sets the minimum interval between two progress
+// reports, DefaultProgressInterval by default.
+func (h *HTTPFile) SetProgressInterval(d time.Duration) *HTTPFile {
+	h.interval = d
+	return h
+}
+
+// SetDownloadDir sets the directory of Download when savePath is empty and
+// the file name comes from the Content-Disposition of the response. The name
+// is sanitized, so it can not escape dir.
+func (h *HTTPFile) SetDownloadDir(dir string) *HTTPFile {
+	h.dir = dir
+	return h
+}
+
+// SetRateLimit caps the bytes per second of each transfer, 0 for no limit.
+func (h *HTTPFile) SetRateLimit(bytesPerSecond int64) *HTTPFile {
+	h.rateLimit = bytesPerSecond
+	return h
+}
+
+// SetLimiter caps the bytes per second of all transfers sharing l, in
+// addition to SetRateLimit.
+func (h *HTTPFile) SetLimiter(l *Limiter) *HTTPFile {
+	h.limiter = l
+	return h
+}
+
+// SetUploadMethod sets the method of uploads, POST by default. Upload uses
+// UploadOptions.Method instead if it is set.
+func (h *HTTPFile) SetUploadMethod(method string) *HTTPFile {
+	h.method = method
+	return h
+}
+
+// uploadMethod returns method, or the default method of uploads if empty.
+func (h *HTTPFile) uploadMethod(method string) string {
+	if method == "" {
+		method = h.method
+	}
+	if method == "" {
+		method = http.MethodPost
+	}
+	return method
+}
+
+// SetAuthorizer sets an Authorizer, such as *SigV4, called for every request
+// right before it is sent.
+func (h *HTTPFile) SetAuthorizer(a Authorizer) *HTTPFile {
+	h.auth = a
+	return h
+}
+
+// SetBasicAuth authorizes requests by the Basic scheme.
+func (h *HTTPFile) SetBasicAuth(username, password string) *HTTPFile {
+	return h.SetAuthorizer(BasicAuth(username, password))
+}
+
+// SetTokenSource authorizes requests by the bearer tokens of src, a 401
+// response refreshes the token once.
+func (h *HTTPFile) SetTokenSource(src TokenSource) *HTTPFile {
+	return h.SetAuthorizer(BearerAuth(src))
+}
+
+// SetDigestAuth authorizes requests by the Digest scheme, answering the
+// challenge of the server.
+func (h *HTTPFile) SetDigestAuth(username, password string) *HTTPFile {
+	return h.SetAuthorizer(DigestAuth(username, password))
+}
+
+// Use adds middleware wrapping every request, see Middleware.
+func (h *HTTPFile) Use(middleware ...Middleware) *HTTPFile {
+	h.middleware = append(h.middleware, middleware...)
+	return h
+}
+
+// OnBeforeRequest calls fn before every request is sent, an error aborts
+// the request.
+func (h *HTTPFile) OnBeforeRequest(fn func(request *http.Request) error) *HTTPFile {
+	return h.Use(BeforeRequest(fn))
+}
+
+// OnAfterResponse calls fn with every response, an error is returned
+// instead of the response.
+func (h *HTTPFile) OnAfterResponse(fn func(resp *http.Response) error) *HTTPFile {
+	return h.Use(AfterResponse(fn))
+}
+
+// SetLogger sets a logger receiving one record per transfer with its
+// method, URL, status, bytes, duration, retries, file, checksum and error.
+// Credentials in the URL and the headers are redacted.
+func (h *HTTPFile) SetLogger(logger *slog.Logger) *HTTPFile {
+	h.logger = logger
+	return h
+}
+
+// SetMetrics sets a collector receiving the metrics of every transfer, such
+// as *Metrics.
+func (h *HTTPFile) SetMetrics(m MetricsCollector) *HTTPFile {
+	h.metrics = m
+	return h
+}
+
+// SetTracer sets a tracer receiving a span per transfer, the requests carry
+// the W3C traceparent header of their span.
+func (h *HTTPFile) SetTracer(tracer Tracer) *HTTPFile {
+	h.tracer = tracer
+	return h
+}
+
+// httpClient returns the client sending requests through the middleware
+// and authorizing them by the Authorizer.
+func (h *HTTPFile) httpClient() *http.Client {
+	return wrapClient(h.client, h.auth, h.middleware)
+}
+
+// Upload sends the files and fields by FormData, the body is streamed from
+// the files with a known Content-Length.
 func (h *HTTPFile) Upload(opts UploadOptions) (*UploadResponse, error) {
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
+	return h.UploadContext(context.Background(), opts)
+}
 
+// UploadContext is Upload with a context, canceling it aborts the transfer.
+func (h *HTTPFile) UploadContext(ctx context.Context, opts UploadOptions) (*UploadResponse, error) {
+	filePaths := make([]string, len(opts.FileItems))
+	for i, item := range opts.FileItems {
+		filePaths[i] = item.FilePath
+	}
+	t := newTransfer(h.observers, "Upload", h.uploadMethod(opts.Method), opts.TargetURL, opts.Header, strings.Join(filePaths, ","))
+	res, err := h.upload(t.context(ctx), opts)
+	t.uploaded(ctx, res, err)
+	return res, err
+}
+
+func (h *HTTPFile) upload(ctx context.Context, opts UploadOptions) (*UploadResponse, error) {
+	body := newMultipartBody()
 	for _, item := range opts.FileItems {
 		flieNames := strings.Split(item.FilePath, "/")
 		fileName := flieNames[len(flieNames)-1]
-		var fileWriter io.Writer
 		if item.ContentType == "" {
 			item.ContentType = "application/octet-stream"
 		}
-		fileWriter, _ = createFormFile(bodyWriter, fileName, item.ContentType)
-		if fileWriter == nil {
-			return nil, errors.New("error writing to buffer")
-		}
-		fh, err := os.Open(item.FilePath)
-		if err != nil {
-			return nil, err
-		}
-		_, err = io.Copy(fileWriter, fh)
-		fh.Close()
+		part, err := newFilePart(item.FieldName, item.FilePath, fileName, item.ContentType)
 		if err != nil {
 			return nil, err
 		}
+		body.addFile(part)
 	}
 	for key, val := range opts.ExtraField {
-		_ = bodyWriter.WriteField(key, val)
-	}
-	bodyWriter.Close()
-	request, err := http.NewRequest(http.MethodPost, opts.TargetURL, bodyBuf)
-	if err != nil {
-		return nil, err
+		body.addField(key, val)
 	}
-	for k, v := range opts.Header {
-		request.Header.Set(k, v)
+	size := body.Size()
+	p := newProgress(h.progress, h.interval)
+	if opts.Progress != nil {
+		p = newProgress(opts.Progress, opts.ProgressInterval)
 	}
-	request.Header.Set("Content-Type", bodyWriter.FormDataContentType())
-	resp, err := h.client.Do(request)
+	t := newThrottle(h.rateLimit, h.limiter)
+	resp, err := newRetrier(ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		reader := body.Reader(ctx)
+		p.reset(0, size)
+		request, err := newRequest(ctx, h.uploadMethod(opts.Method), opts.TargetURL, p.reader(t.reader(ctx, countSent(ctx, reader))), "", opts.Header)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		request.Header.Set("Content-Type", body.ContentType())
+		request.ContentLength = size
+		return request, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	p.done()
 	defer resp.Body.Close()
 	respBody, err := ioutil.ReadAll(resp.Body)
 	res := &UploadResponse{
@@ -83,30 +256,65 @@ func (h *HTTPFile) Upload(opts UploadOptions) (*UploadResponse, error) {
 
 // UploadFile ...
 func (h *HTTPFile) UploadFile(filePath string, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
+	return h.UploadFileContext(context.Background(), filePath, targetURL, Header...)
+}
+
+// UploadFileContext is UploadFile with a context, canceling it aborts the
+// transfer.
+func (h *HTTPFile) UploadFileContext(ctx context.Context, filePath string, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
-	return h.UploadReader(file, targetURL, Header...)
+	t := newTransfer(h.observers, "UploadFile", h.uploadMethod(""), targetURL, firstHeader(Header), filePath)
+	res, err := h.uploadReader(t.context(ctx), file, fileContentType(filePath), targetURL, Header...)
+	t.uploaded(ctx, res, err)
+	return res, err
 }
 
 // UploadReader ...
 func (h *HTTPFile) UploadReader(body io.Reader, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
-	request, err := http.NewRequest(http.MethodPost, targetURL, body)
-	if err != nil {
-		return nil, err
+	return h.UploadReaderContext(context.Background(), body, targetURL, Header...)
+}
+
+// UploadReaderContext is UploadReader with a context, canceling it aborts
+// the transfer.
+func (h *HTTPFile) UploadReaderContext(ctx context.Context, body io.Reader, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
+	t := newTransfer(h.observers, "UploadReader", h.uploadMethod(""), targetURL, firstHeader(Header), "")
+	res, err := h.uploadReader(t.context(ctx), body, "binary/octet-stream", targetURL, Header...)
+	t.uploaded(ctx, res, err)
+	return res, err
+}
+
+// uploadReader sends body as contentType, Header may override it.
+func (h *HTTPFile) uploadReader(ctx context.Context, body io.Reader, contentType, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
+	rw := newRewinder(body)
+	retry := newRetrier(ctx, h.retry)
+	if !rw.rewindable() {
+		retry.policy = nil
 	}
-	request.Header.Set("Content-Type", "binary/octet-stream")
-	if len(Header) > 0 {
-		for k, v := range Header[0] {
-			request.Header.Set(k, v)
+	p := newProgress(h.progress, h.interval)
+	t := newThrottle(h.rateLimit, h.limiter)
+	resp, err := retry.do(h.httpClient(), func() (*http.Request, error) {
+		reqBody, size, err := rw.next()
+		if err != nil {
+			return nil, err
 		}
-	}
-	resp, err := h.client.Do(request)
+		p.reset(0, size)
+		if size != 0 {
+			reqBody = p.reader(t.reader(ctx, countSent(ctx, reqBody)))
+		}
+		request, err := newRequest(ctx, h.uploadMethod(""), targetURL, reqBody, contentType, firstHeader(Header))
+		if err == nil && size >= 0 {
+			request.ContentLength = size
+		}
+		return request, err
+	})
 	if err != nil {
 		return nil, err
 	}
+	p.done()
 	defer resp.Body.Close()
 	respBody, err := ioutil.ReadAll(resp.Body)
 	res := &UploadResponse{
@@ -118,55 +326,135 @@ func (h *HTTPFile) UploadReader(body io.Reader, targetURL string, Header ...map[
 	return res, err
 }
 
-// Download will get filename from 'Content-Disposition' if savePath is empty.
+// Download will get filename from 'Content-Disposition' if savePath is empty,
+// the name is sanitized against path traversal. The file is written to a
+// temporary file and renamed to savePath only when the body is read
+// completely. Responses rejected by the success policy are returned with an
+// *HTTPError before anything is written.
 func (h *HTTPFile) Download(targetURL string, savePath string, Header ...map[string]string) (*DownloadResponse, error) {
-	request, err := http.NewRequest(http.MethodGet, targetURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	if len(Header) > 0 {
-		for k, v := range Header[0] {
-			request.Header.Set(k, v)
+	return h.DownloadContext(context.Background(), targetURL, savePath, Header...)
+}
+
+// DownloadContext is Download with a context, canceling it aborts the
+// transfer and removes the partial file.
+func (h *HTTPFile) DownloadContext(ctx context.Context, targetURL string, savePath string, Header ...map[string]string) (*DownloadResponse, error) {
+	return h.DownloadWithChecksum(ctx, targetURL, savePath, Checksum{}, Header...)
+}
+
+// DownloadWithChecksum is DownloadContext verifying the file against sum
+// while it is written, the file is removed if it does not match. Digests
+// advertised by the server are verified as well, see SetServerDigests.
+func (h *HTTPFile) DownloadWithChecksum(ctx context.Context, targetURL string, savePath string, sum Checksum, Header ...map[string]string) (*DownloadResponse, error) {
+	t := newTransfer(h.observers, "Download", http.MethodGet, targetURL, firstHeader(Header), savePath)
+	res, err := h.download(t.context(ctx), targetURL, &savePath, sum, Header...)
+	t.downloaded(ctx, res, savePath, err)
+	return res, err
+}
+
+// download sets savePath to the path of the file once it is known.
+func (h *HTTPFile) download(ctx context.Context, targetURL string, savePath *string, sum Checksum, Header ...map[string]string) (*DownloadResponse, error) {
+	var res *DownloadResponse
+	var out *downloadFile
+	var v *verifier
+	var offset int64
+	var validator string
+	p := newProgress(h.progress, h.interval)
+	t := newThrottle(h.rateLimit, h.limiter)
+	retry := newRetrier(ctx, h.retry)
+	for {
+		resp, err := retry.do(h.httpClient(), func() (*http.Request, error) {
+			request, err := newRequest(ctx, http.MethodGet, targetURL, nil, "", firstHeader(Header))
+			if err == nil && offset > 0 && validator != "" {
+				request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+				request.Header.Set("If-Range", validator)
+			}
+			return request, err
+		})
+		if err != nil {
+			if out != nil {
+				out.Abort()
+			}
+			return res, err
 		}
-	}
-	resp, err := h.client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	if savePath == "" {
-		_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		res = &DownloadResponse{
+			Res:        resp,
+			Header:     resp.Header,
+			StatusCode: resp.StatusCode,
+		}
+		if !checkStatus(h.success)(resp.StatusCode) {
+			err = newHTTPError(resp)
+			resp.Body.Close()
+			if out != nil {
+				out.Abort()
+			}
+			return res, err
+		}
+		if out == nil {
+			if *savePath == "" {
+				*savePath = downloadPath(h.dir, resp)
+			}
+			if out, err = createDownloadFile(*savePath); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			offset = 0
+			validator = newFileMeta(resp.Header).validator()
+			if v, err = newVerifier(sum, resp, true, !h.skipServerDigests); err == nil {
+				if err = out.Truncate(0); err == nil {
+					_, err = out.Seek(0, io.SeekStart)
+				}
+			}
+		} else if first, _, perr := parseContentRange(resp.Header.Get("Content-Range")); perr != nil {
+			err = perr
+		} else if first != offset {
+			err = ErrRangeMismatch
+		}
+		var n int64
 		if err == nil {
-			savePath = params["filename"]
+			p.reset(offset, contentTotal(offset, resp))
+			n, err = io.Copy(v.wrap(out), p.reader(t.reader(ctx, countReceived(ctx, resp.Body))))
+		}
+		resp.Body.Close()
+		offset += n
+		res.FileSize = offset
+		if err == nil {
+			res.Digests = v.digests()
+			if err = v.verify(); err != nil {
+				out.Abort()
+				return res, err
+			}
+			if err = out.Commit(); err == nil {
+				p.done()
+			}
+			return res, err
+		}
+		ok, waitErr := retry.retry(nil, err)
+		if !ok {
+			out.Abort()
+			if waitErr != nil {
+				err = waitErr
+			}
+			return res, err
 		}
 	}
-	out, err := os.Create(savePath)
-	if err != nil {
-		return nil, err
-	}
-	defer out.Close()
-	defer resp.Body.Close()
-	n, err := io.Copy(out, resp.Body)
-	res := &DownloadResponse{
-		FileSize:   n,
-		Res:        resp,
-		Header:     resp.Header,
-		StatusCode: resp.StatusCode,
-	}
-	return res, err
 }
 
 // Head ...
 func (h *HTTPFile) Head(targetURL string, Header ...map[string]string) (*http.Response, error) {
-	request, err := http.NewRequest(http.MethodHead, targetURL, nil)
-	if err != nil {
-		return nil, err
-	}
-	if len(Header) > 0 {
-		for k, v := range Header[0] {
-			request.Header.Set(k, v)
-		}
-	}
-	return h.client.Do(request)
+	return h.HeadContext(context.Background(), targetURL, Header...)
+}
+
+// HeadContext is Head with a context.
+func (h *HTTPFile) HeadContext(ctx context.Context, targetURL string, Header ...map[string]string) (*http.Response, error) {
+	t := newTransfer(h.observers, "Head", http.MethodHead, targetURL, firstHeader(Header), "")
+	transferCtx := t.context(ctx)
+	resp, err := newRetrier(transferCtx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		return newRequest(transferCtx, http.MethodHead, targetURL, nil, "", firstHeader(Header))
+	})
+	t.responded(ctx, resp, err)
+	return resp, err
 }
 
 // NewFileItem ...
@@ -192,6 +480,8 @@ type FileItem struct {
 	FilePath string
 	// application/octet-stream by default
 	ContentType string
+	// file by default
+	FieldName string
 }
 
 // UploadOptions ...
@@ -201,6 +491,12 @@ type UploadOptions struct {
 	Header    map[string]string
 	// file by default
 	ExtraField map[string]string
+	// POST by default
+	Method string
+	// Progress reports the progress of the upload.
+	Progress func(Progress)
+	// DefaultProgressInterval by default
+	ProgressInterval time.Duration
 }
 
 // UploadResponse ...
@@ -216,22 +512,39 @@ func Upload(opts UploadOptions) (*UploadResponse, error) {
 	return httpFile.Upload(opts)
 }
 
+// UploadContext is Upload with a context.
+func UploadContext(ctx context.Context, opts UploadOptions) (*UploadResponse, error) {
+	return httpFile.UploadContext(ctx, opts)
+}
+
 // UploadFile ...
 func UploadFile(filePath string, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
 	return httpFile.UploadFile(filePath, targetURL, Header...)
 }
 
+// UploadFileContext is UploadFile with a context.
+func UploadFileContext(ctx context.Context, filePath string, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
+	return httpFile.UploadFileContext(ctx, filePath, targetURL, Header...)
+}
+
 // UploadReader ...
 func UploadReader(body io.Reader, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
 	return httpFile.UploadReader(body, targetURL, Header...)
 }
 
+// UploadReaderContext is UploadReader with a context.
+func UploadReaderContext(ctx context.Context, body io.Reader, targetURL string, Header ...map[string]string) (*UploadResponse, error) {
+	return httpFile.UploadReaderContext(ctx, body, targetURL, Header...)
+}
+
 // DownloadResponse ...
 type DownloadResponse struct {
 	Res        *http.Response
 	FileSize   int64
 	Header     http.Header
 	StatusCode int
+	// Digests are the hex digests of the verified algorithms.
+	Digests map[string]string
 }
 
 // Download will get filename from 'Content-Disposition' if savePath is empty.
@@ -239,7 +552,23 @@ func Download(targetURL string, savePath string, Header ...map[string]string) (*
 	return httpFile.Download(targetURL, savePath, Header...)
 }
 
+// DownloadContext is Download with a context.
+func DownloadContext(ctx context.Context, targetURL string, savePath string, Header ...map[string]string) (*DownloadResponse, error) {
+	return httpFile.DownloadContext(ctx, targetURL, savePath, Header...)
+}
+
+// DownloadWithChecksum is Download with a context verifying the file
+// against sum.
+func DownloadWithChecksum(ctx context.Context, targetURL string, savePath string, sum Checksum, Header ...map[string]string) (*DownloadResponse, error) {
+	return httpFile.DownloadWithChecksum(ctx, targetURL, savePath, sum, Header...)
+}
+
 // Head ...
 func Head(targetURL string, Header ...map[string]string) (*http.Response, error) {
 	return httpFile.Head(targetURL, Header...)
 }
+
+// HeadContext is Head with a context.
+func HeadContext(ctx context.Context, targetURL string, Header ...map[string]string) (*http.Response, error) {
+	return httpFile.HeadContext(ctx, targetURL, Header...)
+}
@@ -1,9 +1,19 @@
 package httpfile
 
 import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -135,3 +145,387 @@ func uploadDir(fileName string) string {
 func fileURL() string {
 	return testServer.URL + "/file"
 }
+
+func TestDownloadNotFound(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	savePath := downloadDir("notfound.gif")
+	os.Remove(savePath)
+	resp, err := Download(fileURL(), savePath, map[string]string{"filename": "notfound.gif"})
+	require.NotNil(err)
+	assert.Equal(400, resp.StatusCode)
+	httpErr, ok := err.(*HTTPError)
+	require.True(ok)
+	assert.Equal(400, httpErr.StatusCode)
+	assert.Contains(string(httpErr.Body), "no such file")
+	_, err = os.Stat(savePath)
+	assert.True(os.IsNotExist(err))
+
+	// accept every status code
+	resp, err = New(nil).SetSuccessPolicy(func(int) bool { return true }).
+		Download(fileURL(), savePath, map[string]string{"filename": "notfound.gif"})
+	require.Nil(err)
+	assert.Equal(400, resp.StatusCode)
+	_, err = os.Stat(savePath)
+	assert.Nil(err)
+}
+
+func TestDownloadContext(t *testing.T) {
+	assert := assert.New(t)
+
+	savePath := downloadDir("canceled.bin")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := DownloadContext(ctx, slowURL(), savePath)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	_, err = os.Stat(savePath)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestHTTPFileRetry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := New(nil).SetRetryPolicy(&Backoff{MaxRetries: 3, BaseDelay: time.Millisecond})
+	res, err := h.UploadReader(strings.NewReader("retry"), flakyURL("httpfile-reader", 2))
+	require.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.Equal("retry", string(res.Result))
+	assert.Len(flakyRanges("httpfile-reader"), 3)
+
+	// a body that is not an io.Seeker is sent once
+	res, err = h.UploadReader(struct{ io.Reader }{strings.NewReader("retry")}, flakyURL("httpfile-once", 2))
+	require.Nil(err)
+	assert.Equal(503, res.StatusCode)
+	assert.Len(flakyRanges("httpfile-once"), 1)
+
+	resp, err := h.Download(flakyURL("httpfile-cut", 1)+"&cut=1", downloadDir("retry.gif"))
+	require.Nil(err)
+	assert.Equal(206, resp.StatusCode)
+	assert.Equal(int64(185210), resp.FileSize)
+	assert.Equal([]string{"", "", "bytes=1000-"}, flakyRanges("httpfile-cut"))
+}
+
+func TestBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &Backoff{MaxRetries: 2, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	delay, ok := b.Retry(0, nil, syscall.ECONNRESET)
+	assert.True(ok)
+	assert.True(delay >= 500*time.Millisecond && delay <= time.Second)
+	delay, ok = b.Retry(1, nil, syscall.ECONNRESET)
+	assert.True(ok)
+	assert.True(delay >= time.Second && delay <= 2*time.Second)
+	_, ok = b.Retry(2, nil, syscall.ECONNRESET)
+	assert.False(ok)
+	_, ok = b.Retry(0, nil, context.Canceled)
+	assert.False(ok)
+	_, ok = b.Retry(0, nil, &url.Error{Op: "Get", URL: "http://localhost", Err: io.ErrUnexpectedEOF})
+	assert.True(ok)
+	_, ok = b.Retry(0, nil, &url.Error{Op: "Get", URL: "ftp://localhost", Err: errors.New(`unsupported protocol scheme "ftp"`)})
+	assert.False(ok)
+	_, ok = b.Retry(0, nil, &url.Error{Op: "Get", URL: "https://localhost", Err: x509.UnknownAuthorityError{}})
+	assert.False(ok)
+
+	resp := &http.Response{StatusCode: 429, Header: http.Header{"Retry-After": {"3"}}}
+	delay, ok = b.Retry(0, resp, nil)
+	assert.True(ok)
+	assert.Equal(3*time.Second, delay)
+	resp = &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": {"3600"}}}
+	delay, ok = b.Retry(0, resp, nil)
+	assert.True(ok)
+	assert.Equal(10*time.Second, delay)
+	_, ok = b.Retry(0, &http.Response{StatusCode: 404}, nil)
+	assert.False(ok)
+}
+
+func TestHTTPFileUploadContentLength(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res, err := Upload(UploadOptions{
+		FileItems: []FileItem{
+			NewFileItem(uploadDir("test.gif")),
+			NewFileItem(uploadDir("test.bmp")),
+		},
+		TargetURL:  inspectURL(),
+		ExtraField: map[string]string{"a": "1", "b": "2"},
+	})
+	require.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.NotEqual("-1", res.Header.Get("X-Content-Length"))
+	assert.Equal(res.Header.Get("X-Body-Length"), res.Header.Get("X-Content-Length"))
+
+	// the length of a buffer is sent although it is wrapped by the progress
+	res, err = New(nil).SetProgress(func(Progress) {}).UploadReader(bytes.NewBufferString("buffer"), inspectURL())
+	require.Nil(err)
+	assert.Equal("6", res.Header.Get("X-Content-Length"))
+	assert.Equal("6", res.Header.Get("X-Body-Length"))
+	assert.Equal("binary/octet-stream", res.Header.Get("X-Content-Type"))
+
+	// a file is sent with the type of its extension, like UploadByStream
+	res, err = New(nil).SetUploadMethod(http.MethodPut).UploadFile(uploadDir("test.gif"), inspectURL())
+	require.Nil(err)
+	assert.Equal("PUT", res.Header.Get("X-Method"))
+	assert.Equal("image/gif", res.Header.Get("X-Content-Type"))
+}
+
+func TestHTTPFileProgress(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var last Progress
+	_, err := Upload(UploadOptions{
+		FileItems: NewFileItems(uploadDir("test.gif")),
+		TargetURL: inspectURL(),
+		Progress: func(p Progress) {
+			last = p
+		},
+	})
+	require.Nil(err)
+	assert.True(last.Done)
+	assert.Equal(last.Total, last.Transferred)
+
+	h := New(nil).SetProgress(func(p Progress) {
+		last = p
+	})
+	_, err = h.UploadReader(strings.NewReader("progress"), inspectURL())
+	require.Nil(err)
+	assert.Equal(Progress{Transferred: 8, Total: 8, Rate: last.Rate, Done: true}, last)
+
+	_, err = h.Download(resumeURL(), downloadDir("progress.gif"))
+	require.Nil(err)
+	assert.True(last.Done)
+	assert.Equal(int64(185210), last.Transferred)
+}
+
+func TestDownloadWithChecksum(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	savePath := downloadDir("checksum2.gif")
+	os.Remove(savePath)
+	resp, err := DownloadWithChecksum(context.Background(), resumeURL(), savePath, Checksum{Algorithm: CRC32C, Hex: "00000000"})
+	var checksumErr *ChecksumError
+	require.True(errors.As(err, &checksumErr))
+	assert.Equal(CRC32C, checksumErr.Algorithm)
+	_, err = os.Stat(savePath)
+	assert.True(os.IsNotExist(err))
+
+	resp, err = DownloadWithChecksum(context.Background(), resumeURL(), savePath, Checksum{Algorithm: CRC32C, Hex: checksumErr.Actual})
+	require.Nil(err)
+	assert.Equal(checksumErr.Actual, resp.Digests[CRC32C])
+
+	_, err = Download(headerURL("Digest: sha=AAAA"), savePath)
+	require.True(errors.As(err, &checksumErr))
+	assert.Equal("Digest", checksumErr.Source)
+	_, err = New(nil).SetServerDigests(false).Download(headerURL("Digest: sha=AAAA"), savePath)
+	require.Nil(err)
+}
+
+func TestHTTPFileDownloadDir(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir := downloadDir("dir2")
+	require.Nil(os.MkdirAll(dir, 0755))
+	_, err := New(nil).SetDownloadDir(dir).Download(headerURL(`Content-Disposition: attachment; filename="/etc/evil.gif"`), "")
+	require.Nil(err)
+	_, err = os.Stat(dir + "/evil.gif")
+	assert.Nil(err)
+}
+
+func TestHTTPFileRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := New(nil).SetRateLimit(1 << 20)
+	start := time.Now()
+	res, err := h.UploadFile(uploadDir("test.gif"), inspectURL())
+	require.Nil(err)
+	assert.Equal("185210", res.Header.Get("X-Body-Length"))
+	resp, err := h.Download(resumeURL(), downloadDir("limited2.gif"))
+	require.Nil(err)
+	assert.Equal(int64(185210), resp.FileSize)
+	assert.True(time.Since(start) >= 200*time.Millisecond)
+}
+
+func TestUploadFieldName(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	res, err := Upload(UploadOptions{
+		FileItems: []FileItem{
+			{FilePath: uploadDir("test.gif"), FieldName: "avatar"},
+			NewFileItem(uploadDir("test.gif")),
+		},
+		TargetURL: testServer.URL + "/form",
+	})
+	require.Nil(err)
+	assert.Equal([]string{"avatar=test.gif:185210", "file=test.gif:185210"}, res.Header["X-File"])
+}
+
+func TestHTTPFileUploadMethod(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := New(nil).SetUploadMethod(http.MethodPatch)
+	res, err := h.Upload(UploadOptions{
+		FileItems: NewFileItems(uploadDir("test.gif")),
+		TargetURL: inspectURL(),
+		Method:    http.MethodPut,
+	})
+	require.Nil(err)
+	assert.Equal("PUT", res.Header.Get("X-Method"))
+
+	res, err = h.UploadReader(strings.NewReader("patch"), inspectURL())
+	require.Nil(err)
+	assert.Equal("PATCH", res.Header.Get("X-Method"))
+	assert.Equal("5", res.Header.Get("X-Content-Length"))
+}
+
+func TestHTTPFileAuthorizer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := New(nil).SetAuthorizer(testSigV4).SetUploadMethod(http.MethodPut)
+	res, err := h.UploadReader(strings.NewReader("signed"), testServer.URL+"/minio/bucket/signed.txt")
+	require.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	resp, err := h.Download(testServer.URL+"/minio/bucket/signed.txt", downloadDir("signed.txt"))
+	require.Nil(err)
+	assert.Equal(int64(6), resp.FileSize)
+
+	var calls int
+	h = New(nil).SetAuthorizer(AuthorizerFunc(func(request *http.Request) error {
+		calls++
+		request.Header.Set("testheader", "authorized")
+		return nil
+	}))
+	res, err = h.UploadFile(uploadDir("test.gif"), fileURL())
+	require.Nil(err)
+	assert.Equal("authorized", res.Header.Get("testheader"))
+	assert.Equal(1, calls)
+}
+
+func TestHTTPFileAuthSchemes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	h := New(nil).SetDigestAuth("user", "pass")
+	for i := 0; i < 2; i++ {
+		// the body can not be read again, it waits for 100 Continue
+		res, err := h.UploadReader(struct{ io.Reader }{strings.NewReader("digest")}, testServer.URL+"/digest")
+		require.Nil(err)
+		assert.Equal(200, res.StatusCode)
+		assert.Equal("6", res.Header.Get("X-Body-Length"))
+	}
+
+	h = New(nil).SetTokenSource(TokenSourceFunc(func(refresh bool) (string, error) {
+		return "stale", nil
+	}))
+	res, err := h.UploadReader(strings.NewReader("bearer"), testServer.URL+"/bearer")
+	require.Nil(err)
+	assert.Equal(401, res.StatusCode)
+}
+
+func TestHTTPFileMiddleware(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// headers set by middleware are signed by the Authorizer
+	var ids []string
+	h := New(nil).SetAuthorizer(testSigV4).SetUploadMethod(http.MethodPut).
+		OnBeforeRequest(func(request *http.Request) error {
+			request.Header.Set("X-Request-Id", "42")
+			return nil
+		}).
+		Use(func(next RoundTripFunc) RoundTripFunc {
+			return func(request *http.Request) (*http.Response, error) {
+				ids = append(ids, request.Header.Get("X-Request-Id"))
+				return next(request)
+			}
+		})
+	res, err := h.UploadReader(strings.NewReader("middleware"), testServer.URL+"/minio/bucket/middleware.txt")
+	require.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.Equal([]string{"42"}, ids)
+}
+
+func TestHTTPFileLogger(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	h := New(nil).SetLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+	res, err := h.UploadFile(uploadDir("test.gif"), inspectURL())
+	require.Nil(err)
+	assert.Equal(200, res.StatusCode)
+	assert.Contains(buf.String(), "level=INFO msg=upload method=POST")
+	assert.Contains(buf.String(), "bytes="+res.Header.Get("X-Body-Length"))
+	assert.Contains(buf.String(), "file="+uploadDir("test.gif"))
+
+	buf.Reset()
+	_, err = h.Download(fileURL(), downloadDir("logger.gif"), map[string]string{"filename": "notfound.gif"})
+	require.NotNil(err)
+	assert.Contains(buf.String(), "level=ERROR msg=download method=GET")
+	assert.Contains(buf.String(), "status=400")
+	assert.Contains(buf.String(), "header.filename=notfound.gif")
+}
+
+type recordingMetrics struct {
+	started []string
+	done    []TransferStats
+}
+
+func (m *recordingMetrics) TransferStarted(operation, host string) {
+	m.started = append(m.started, operation)
+}
+
+func (m *recordingMetrics) TransferDone(stats TransferStats) {
+	m.done = append(m.done, stats)
+}
+
+func TestHTTPFileMetrics(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	metrics := &recordingMetrics{}
+	h := New(nil).SetMetrics(metrics)
+	_, err := h.UploadReader(strings.NewReader("metrics"), inspectURL())
+	require.Nil(err)
+	resp, err := h.Download(resumeURL(), downloadDir("metrics.gif"))
+	require.Nil(err)
+	_, err = h.Head(resumeURL())
+	require.Nil(err)
+
+	assert.Equal([]string{"UploadReader", "Download", "Head"}, metrics.started)
+	require.Len(metrics.done, 3)
+	assert.Equal(int64(7), metrics.done[0].BytesSent)
+	assert.Equal(resp.FileSize, metrics.done[1].BytesReceived)
+	assert.Equal(200, metrics.done[2].Status)
+	assert.Equal(strings.TrimPrefix(testServer.URL, "http://"), metrics.done[2].Host)
+}
+
+func TestHTTPFileTracing(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	recorder := NewSpanRecorder()
+	h := New(nil).SetTracer(recorder)
+	res, err := h.UploadReader(strings.NewReader("tracing"), inspectURL())
+	require.Nil(err)
+	_, err = h.Download(fileURL(), downloadDir("tracing.gif"), map[string]string{"filename": "notfound.gif"})
+	require.NotNil(err)
+
+	spans := recorder.Spans()
+	require.Len(spans, 4)
+	assert.Equal("httpfile.attempt", spans[0].Name)
+	assert.Equal("httpfile.UploadReader", spans[1].Name)
+	assert.Equal(spans[0].Context.TraceParent(), res.Header.Get("X-Traceparent"))
+	assert.Equal(int64(7), spans[1].Attributes["httpfile.bytes_sent"])
+	assert.Equal("httpfile.Download", spans[3].Name)
+	assert.Equal(spans[3].Context, spans[2].Parent)
+	assert.NotNil(spans[3].Err)
+}
@@ -0,0 +1,154 @@
+package httpfile
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// redacted replaces the values of credentials in logs.
+const redacted = "xxxxx"
+
+// sensitiveNames are header and query parameter names carrying credentials.
+var sensitiveNames = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"key":                 true,
+	"apikey":              true,
+	"api_key":             true,
+	"api-key":             true,
+	"x-api-key":           true,
+	"sig":                 true,
+}
+
+// sensitiveParts are parts of names carrying credentials, such as
+// X-Amz-Signature and access_token.
+var sensitiveParts = []string{"signature", "token", "secret", "password", "credential"}
+
+func sensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	if sensitiveNames[name] {
+		return true
+	}
+	for _, part := range sensitiveParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL removes the password and the credentials in the query of
+// rawURL, such as the signature of a presigned URL.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+			return rawURL[:i]
+		}
+		return rawURL
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k, vs := range query {
+			if sensitiveName(k) {
+				for i := range vs {
+					vs[i] = redacted
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+// redactHeader returns the header as attributes sorted by name, credentials
+// are redacted.
+func redactHeader(header map[string]string) []any {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]any, 0, len(keys))
+	for _, k := range keys {
+		v := header[k]
+		if sensitiveName(k) {
+			v = redacted
+		}
+		attrs = append(attrs, slog.String(k, v))
+	}
+	return attrs
+}
+
+// redactError returns the message of err, the URL of a *url.Error or an
+// *HTTPError is redacted.
+func redactError(err error) string {
+	msg := err.Error()
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && urlErr.URL != "" {
+		msg = strings.ReplaceAll(msg, urlErr.URL, redactURL(urlErr.URL))
+	}
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.URL != "" {
+		redactedErr := *httpErr
+		redactedErr.URL = redactURL(httpErr.URL)
+		msg = strings.ReplaceAll(msg, httpErr.Error(), redactedErr.Error())
+	}
+	return msg
+}
+
+// logMessage returns the message of the record of operation, uploads and
+// downloads are logged as "upload" and "download" whatever method started
+// them.
+func logMessage(operation string) string {
+	switch operation {
+	case "UploadMultipart":
+		return "multipart upload"
+	case "UploadTus":
+		return "tus upload"
+	}
+	if strings.HasPrefix(operation, "Upload") {
+		return "upload"
+	}
+	return strings.ToLower(operation)
+}
+
+// log emits the record of the transfer, at error level if it failed or
+// was rejected.
+func (t *transfer) log(ctx context.Context, stats TransferStats, checksum string) {
+	attrs := []slog.Attr{
+		slog.String("method", t.method),
+		slog.String("url", redactURL(t.url)),
+	}
+	if stats.Status > 0 {
+		attrs = append(attrs, slog.Int("status", stats.Status))
+	}
+	attrs = append(attrs,
+		slog.Int64("bytes", stats.BytesSent+stats.BytesReceived),
+		slog.Duration("duration", stats.Duration),
+		slog.Int("retries", stats.Retries),
+	)
+	if t.filePath != "" {
+		attrs = append(attrs, slog.String("file", t.filePath))
+	}
+	if checksum != "" {
+		attrs = append(attrs, slog.String("checksum", checksum))
+	}
+	if len(t.header) > 0 {
+		attrs = append(attrs, slog.Group("header", redactHeader(t.header)...))
+	}
+	level := slog.LevelInfo
+	if stats.Err != nil || stats.Status >= 400 {
+		level = slog.LevelError
+	}
+	if stats.Err != nil {
+		attrs = append(attrs, slog.String("error", redactError(stats.Err)))
+	}
+	t.logger.LogAttrs(ctx, level, logMessage(t.operation), attrs...)
+}
@@ -0,0 +1,259 @@
+package httpfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsCollector receives the metrics of transfers. Operation is the name
+// of the method, such as "Upload", "UploadByStream", "Download", "Head" or
+// "Get", and host is the host of the target URL.
+type MetricsCollector interface {
+	TransferStarted(operation, host string)
+	TransferDone(stats TransferStats)
+}
+
+// TransferStats are the metrics of one transfer, including all its retries.
+type TransferStats struct {
+	Operation string
+	Host      string
+	// Status is the status code of the final response, 0 if there is none.
+	Status        int
+	BytesSent     int64
+	BytesReceived int64
+	Duration      time.Duration
+	Retries       int
+	Err           error
+}
+
+// DefaultDurationBuckets are the upper bounds in seconds of the transfer
+// duration histogram of NewMetrics.
+var DefaultDurationBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// DefaultSizeBuckets are the upper bounds in bytes of the transfer size
+// histogram of NewMetrics.
+var DefaultSizeBuckets = []float64{1 << 10, 16 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20, 256 << 20, 1 << 30}
+
+// Metrics is a MetricsCollector keeping counters and histograms in memory,
+// labeled by host and operation. It serves them in the Prometheus text
+// format, so it can be scraped without depending on a client library:
+//
+//	metrics := httpfile.NewMetrics()
+//	http.Handle("/metrics", metrics)
+//	httpfile.NewReq(url, path).SetMetrics(metrics).Download()
+type Metrics struct {
+	mu              sync.Mutex
+	durationBuckets []float64
+	sizeBuckets     []float64
+	series          map[metricLabels]*metricSeries
+}
+
+type metricLabels struct {
+	host      string
+	operation string
+}
+
+type metricSeries struct {
+	inFlight      int64
+	statuses      map[string]int64
+	bytesSent     int64
+	bytesReceived int64
+	retries       int64
+	duration      histogram
+	size          histogram
+}
+
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(buckets))
+	}
+	for i, upper := range buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// NewMetrics returns Metrics with DefaultDurationBuckets and
+// DefaultSizeBuckets.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		durationBuckets: DefaultDurationBuckets,
+		sizeBuckets:     DefaultSizeBuckets,
+		series:          make(map[metricLabels]*metricSeries),
+	}
+}
+
+// SetDurationBuckets sets the upper bounds in seconds of the duration
+// histogram, the durations observed so far are reset.
+func (m *Metrics) SetDurationBuckets(buckets ...float64) *Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.durationBuckets = sortedBuckets(buckets)
+	for _, s := range m.series {
+		s.duration = histogram{}
+	}
+	return m
+}
+
+// SetSizeBuckets sets the upper bounds in bytes of the size histogram, the
+// sizes observed so far are reset.
+func (m *Metrics) SetSizeBuckets(buckets ...float64) *Metrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sizeBuckets = sortedBuckets(buckets)
+	for _, s := range m.series {
+		s.size = histogram{}
+	}
+	return m
+}
+
+func sortedBuckets(buckets []float64) []float64 {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return buckets
+}
+
+// get returns the series of labels, it must be called with mu held.
+func (m *Metrics) get(labels metricLabels) *metricSeries {
+	s, ok := m.series[labels]
+	if !ok {
+		s = &metricSeries{statuses: make(map[string]int64)}
+		m.series[labels] = s
+	}
+	return s
+}
+
+// TransferStarted implements MetricsCollector.
+func (m *Metrics) TransferStarted(operation, host string) {
+	m.mu.Lock()
+	m.get(metricLabels{host: host, operation: operation}).inFlight++
+	m.mu.Unlock()
+}
+
+// TransferDone implements MetricsCollector.
+func (m *Metrics) TransferDone(stats TransferStats) {
+	status := "none"
+	if stats.Status > 0 {
+		status = strconv.Itoa(stats.Status)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.get(metricLabels{host: stats.Host, operation: stats.Operation})
+	s.inFlight--
+	s.statuses[status]++
+	s.bytesSent += stats.BytesSent
+	s.bytesReceived += stats.BytesReceived
+	s.retries += int64(stats.Retries)
+	s.duration.observe(m.durationBuckets, stats.Duration.Seconds())
+	s.size.observe(m.sizeBuckets, float64(stats.BytesSent+stats.BytesReceived))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text format.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	labels := make([]metricLabels, 0, len(m.series))
+	for l := range m.series {
+		labels = append(labels, l)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].host != labels[j].host {
+			return labels[i].host < labels[j].host
+		}
+		return labels[i].operation < labels[j].operation
+	})
+	b := bufio.NewWriter(w)
+	header := func(name, kind, help string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("httpfile_transfers_total", "counter", "Completed transfers by status code, none if there was no response.")
+	for _, l := range labels {
+		s := m.series[l]
+		statuses := make([]string, 0, len(s.statuses))
+		for status := range s.statuses {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(b, "httpfile_transfers_total{%s,status=%q} %d\n", l.format(), status, s.statuses[status])
+		}
+	}
+	header("httpfile_transfers_in_flight", "gauge", "Transfers in progress.")
+	for _, l := range labels {
+		fmt.Fprintf(b, "httpfile_transfers_in_flight{%s} %d\n", l.format(), m.series[l].inFlight)
+	}
+	header("httpfile_uploaded_bytes_total", "counter", "Bytes of request bodies sent.")
+	for _, l := range labels {
+		fmt.Fprintf(b, "httpfile_uploaded_bytes_total{%s} %d\n", l.format(), m.series[l].bytesSent)
+	}
+	header("httpfile_downloaded_bytes_total", "counter", "Bytes of response bodies received.")
+	for _, l := range labels {
+		fmt.Fprintf(b, "httpfile_downloaded_bytes_total{%s} %d\n", l.format(), m.series[l].bytesReceived)
+	}
+	header("httpfile_retries_total", "counter", "Retried attempts of transfers.")
+	for _, l := range labels {
+		fmt.Fprintf(b, "httpfile_retries_total{%s} %d\n", l.format(), m.series[l].retries)
+	}
+	header("httpfile_transfer_duration_seconds", "histogram", "Duration of transfers including retries.")
+	for _, l := range labels {
+		writeHistogram(b, "httpfile_transfer_duration_seconds", l, m.durationBuckets, &m.series[l].duration)
+	}
+	header("httpfile_transfer_size_bytes", "histogram", "Bytes sent and received by transfers.")
+	for _, l := range labels {
+		writeHistogram(b, "httpfile_transfer_size_bytes", l, m.sizeBuckets, &m.series[l].size)
+	}
+	return b.Flush()
+}
+
+func writeHistogram(w io.Writer, name string, l metricLabels, buckets []float64, h *histogram) {
+	for i, upper := range buckets {
+		var count int64
+		if h.counts != nil {
+			count = h.counts[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, l.format(), formatFloat(upper), count)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, l.format(), h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, l.format(), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, l.format(), h.count)
+}
+
+func (l metricLabels) format() string {
+	return fmt.Sprintf("host=%s,operation=%s", quoteLabel(l.host), quoteLabel(l.operation))
+}
+
+// quoteLabel quotes a label value, escaping backslash, quote and newline.
+func quoteLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WritePrometheus(w)
+}
@@ -0,0 +1,80 @@
+package httpfile
+
+import (
+	"net/http"
+)
+
+// RoundTripFunc sends a request and returns its response, it is the
+// http.RoundTripper a Middleware wraps.
+type RoundTripFunc func(request *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (fn RoundTripFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return fn(request)
+}
+
+// Middleware wraps the sending of requests. It is called for every attempt
+// and redirect with a copy of the request, so it may set headers, before
+// the Authorizer signs it. The first Middleware added is the outermost.
+type Middleware func(next RoundTripFunc) RoundTripFunc
+
+// BeforeRequest returns a Middleware calling fn before a request is sent,
+// an error aborts the request.
+func BeforeRequest(fn func(request *http.Request) error) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			if err := fn(request); err != nil {
+				if request.Body != nil {
+					request.Body.Close()
+				}
+				return nil, err
+			}
+			return next(request)
+		}
+	}
+}
+
+// AfterResponse returns a Middleware calling fn with every response, an
+// error is returned instead of the response.
+func AfterResponse(fn func(resp *http.Response) error) Middleware {
+	return func(next RoundTripFunc) RoundTripFunc {
+		return func(request *http.Request) (*http.Response, error) {
+			resp, err := next(request)
+			if err != nil {
+				return resp, err
+			}
+			if err = fn(resp); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			return resp, nil
+		}
+	}
+}
+
+// wrapClient returns a copy of client sending its requests through
+// middleware and authorizing them by auth, or client if there are none.
+func wrapClient(client *http.Client, auth Authorizer, middleware []Middleware) *http.Client {
+	if auth == nil && len(middleware) == 0 {
+		return client
+	}
+	transport := client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if auth != nil {
+		transport = &authTransport{base: transport, auth: auth}
+	}
+	if len(middleware) > 0 {
+		next := RoundTripFunc(transport.RoundTrip)
+		for i := len(middleware) - 1; i >= 0; i-- {
+			next = middleware[i](next)
+		}
+		transport = RoundTripFunc(func(request *http.Request) (*http.Response, error) {
+			return next(request.Clone(request.Context()))
+		})
+	}
+	wrapped := *client
+	wrapped.Transport = transport
+	return &wrapped
+}
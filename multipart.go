@@ -0,0 +1,162 @@
+package httpfile
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+)
+
+// formPart is a file or a field of a multipart body.
+type formPart struct {
+	fieldName   string
+	fileName    string
+	contentType string
+	value       string
+	// filePath is the content of a file part, read when the body is sent.
+	filePath string
+	// reader is the content of a file part given as io.Reader.
+	reader *rewinder
+	// size is -1 if unknown.
+	size int64
+}
+
+func newFilePart(fieldName, filePath, fileName, contentType string) (*formPart, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return &formPart{
+		fieldName:   fieldName,
+		fileName:    fileName,
+		contentType: contentType,
+		filePath:    filePath,
+		size:        stat.Size(),
+	}, nil
+}
+
+// newReaderPart returns a file part read from r, it can only be sent more
+// than once if r is an io.Seeker.
+func newReaderPart(fieldName, fileName, contentType string, r io.Reader) *formPart {
+	rw := newRewinder(r)
+	return &formPart{
+		fieldName:   fieldName,
+		fileName:    fileName,
+		contentType: contentType,
+		reader:      rw,
+		size:        rw.size,
+	}
+}
+
+func (p *formPart) isFile() bool {
+	return p.filePath != "" || p.reader != nil
+}
+
+// multipartBody produces a multipart/form-data body while it is sent, so
+// files are never held in memory.
+type multipartBody struct {
+	boundary string
+	parts    []*formPart
+}
+
+func newMultipartBody() *multipartBody {
+	return &multipartBody{boundary: multipart.NewWriter(ioutil.Discard).Boundary()}
+}
+
+func (m *multipartBody) addFile(p *formPart) {
+	m.parts = append(m.parts, p)
+}
+
+func (m *multipartBody) addField(key, value string) {
+	m.parts = append(m.parts, &formPart{fieldName: key, value: value})
+}
+
+// ContentType returns the Content-Type of the body with its boundary.
+func (m *multipartBody) ContentType() string {
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// Size returns the exact length of the body, -1 if a reader of unknown
+// length is part of it.
+func (m *multipartBody) Size() int64 {
+	counter := &countWriter{}
+	w := multipart.NewWriter(counter)
+	w.SetBoundary(m.boundary)
+	for _, p := range m.parts {
+		if p.size < 0 {
+			return -1
+		}
+		m.writePart(context.Background(), w, p, true)
+		counter.n += p.size
+	}
+	w.Close()
+	return counter.n
+}
+
+// rewindable reports whether the body can be sent more than once.
+func (m *multipartBody) rewindable() bool {
+	for _, p := range m.parts {
+		if p.reader != nil && !p.reader.rewindable() {
+			return false
+		}
+	}
+	return true
+}
+
+// Reader returns a new reader of the body, it is written by a goroutine
+// until the reader is closed or the body is complete.
+func (m *multipartBody) Reader(ctx context.Context) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w := multipart.NewWriter(pw)
+		w.SetBoundary(m.boundary)
+		for _, p := range m.parts {
+			if err := m.writePart(ctx, w, p, false); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+// writePart writes the header and the content of p, the content of files is
+// skipped if headerOnly.
+func (m *multipartBody) writePart(ctx context.Context, w *multipart.Writer, p *formPart, headerOnly bool) error {
+	if !p.isFile() {
+		return w.WriteField(p.fieldName, p.value)
+	}
+	fw, err := createFormFile(w, p.fieldName, p.fileName, p.contentType)
+	if err != nil || headerOnly {
+		return err
+	}
+	var r io.Reader
+	if p.reader != nil {
+		if r, _, err = p.reader.next(); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(p.filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	n, err := io.Copy(fw, &contextReader{ctx: ctx, r: r})
+	if err == nil && p.size >= 0 && n != p.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,137 @@
+package httpfile
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultProgressInterval is the minimum interval between two progress
+// reports, unless another one is set.
+const DefaultProgressInterval = 500 * time.Millisecond
+
+// Progress is reported while a file is transferred.
+type Progress struct {
+	// Transferred includes the bytes of a resumed partial download.
+	Transferred int64
+	// Total is -1 if unknown.
+	Total int64
+	// Rate is the average bytes per second of this transfer.
+	Rate float64
+	// ETA is the estimated remaining time, 0 if unknown.
+	ETA time.Duration
+	// Done is true for the last report of a completed transfer.
+	Done bool
+}
+
+// progress throttles the reports of a transfer, a nil *progress does
+// nothing.
+type progress struct {
+	mu          sync.Mutex
+	fn          func(Progress)
+	interval    time.Duration
+	start       time.Time
+	last        time.Time
+	offset      int64
+	transferred int64
+	total       int64
+}
+
+func newProgress(fn func(Progress), interval time.Duration) *progress {
+	if fn == nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return &progress{fn: fn, interval: interval, total: -1}
+}
+
+// reset starts a transfer or a retried attempt at offset of total bytes.
+func (p *progress) reset(offset, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.start = time.Now()
+	p.offset = offset
+	p.transferred = offset
+	p.total = total
+	p.mu.Unlock()
+}
+
+func (p *progress) add(n int64) {
+	if p == nil || n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.transferred += n
+	now := time.Now()
+	if now.Sub(p.last) < p.interval {
+		p.mu.Unlock()
+		return
+	}
+	p.last = now
+	report := p.snapshot(now)
+	p.mu.Unlock()
+	p.fn(report)
+}
+
+// done sends the final report.
+func (p *progress) done() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	report := p.snapshot(time.Now())
+	p.mu.Unlock()
+	report.Done = true
+	p.fn(report)
+}
+
+func (p *progress) snapshot(now time.Time) Progress {
+	report := Progress{Transferred: p.transferred, Total: p.total}
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		report.Rate = float64(p.transferred-p.offset) / elapsed
+	}
+	if report.Rate > 0 && p.total > p.transferred {
+		report.ETA = time.Duration(float64(p.total-p.transferred) / report.Rate * float64(time.Second))
+	}
+	return report
+}
+
+// contentTotal returns the size of the whole file of a response starting at
+// offset, -1 if unknown.
+func contentTotal(offset int64, resp *http.Response) int64 {
+	if resp.ContentLength < 0 {
+		return -1
+	}
+	return offset + resp.ContentLength
+}
+
+// reader counts the bytes read from r, it closes r if it is an io.Closer.
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &progressReader{r: r, p: p}
+}
+
+type progressReader struct {
+	r io.Reader
+	p *progress
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.p.add(int64(n))
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -2,7 +2,6 @@ package httpfile
 
 import (
 	"encoding/json"
-	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -14,23 +13,32 @@ import (
 // Response ...
 type Response struct {
 	err       error
+	httpErr   *HTTPError
 	resp      *http.Response
 	filePath  string
 	targetURL string
+	digests   map[string]string
+	skipped   bool
+	refreshed bool
+	uploadURL string
+	// accepted is set when the success policy accepted the status code
+	accepted bool
 }
 
+// Error returns the error of the request, or an *HTTPError if the status
+// code is >= 400 and not accepted by the success policy. The body of the
+// response is still readable afterwards.
 func (a *Response) Error() error {
 	if a.err != nil {
 		return a.err
 	}
-	if a.resp != nil && a.resp.StatusCode >= 400 {
-		res, err := a.BodyString()
-		if err != nil {
-			return err
+	if a.resp != nil && a.resp.StatusCode >= 400 && !a.accepted {
+		if a.httpErr == nil {
+			a.httpErr = newHTTPError(a.resp)
 		}
-		return errors.New(res)
+		return a.httpErr
 	}
-	return a.err
+	return nil
 }
 
 // Bytes ...
@@ -133,6 +141,28 @@ func (a *Response) Body() io.ReadCloser {
 	return a.resp.Body
 }
 
+// Digests returns the hex digests computed while downloading by algorithm,
+// only algorithms that were verified are computed.
+func (a *Response) Digests() map[string]string {
+	return a.digests
+}
+
+// Skipped reports whether the download was skipped because the file exists.
+func (a *Response) Skipped() bool {
+	return a.skipped
+}
+
+// Refreshed reports whether Download wrote the file, it is false if the file
+// was not modified or skipped.
+func (a *Response) Refreshed() bool {
+	return a.refreshed
+}
+
+// UploadURL returns the URL of the tus upload.
+func (a *Response) UploadURL() string {
+	return a.uploadURL
+}
+
 // Int64Result ...
 type Int64Result struct {
 	Length int64
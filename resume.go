@@ -0,0 +1,106 @@
+package httpfile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrRangeMismatch is returned when a resumed download receives a
+// Content-Range that does not start at the local file size.
+var ErrRangeMismatch = errors.New("Content-Range does not match local file")
+
+// fileMeta holds the validators of a remote file, persisted next to the
+// local copy.
+type fileMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+func newFileMeta(header http.Header) *fileMeta {
+	return &fileMeta{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+}
+
+// validator returns the value to send in If-Range. Weak ETags cannot be
+// used for range requests, Last-Modified is used instead.
+func (m *fileMeta) validator() string {
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+func readFileMeta(path string) (*fileMeta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta := &fileMeta{}
+	if err = json.Unmarshal(data, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func writeFileMeta(path string, meta *fileMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func resumeMetaPath(filePath string) string {
+	return filePath + ".resume"
+}
+
+// resumeOffset returns the size of a partial download of filePath and the
+// validator recorded when it was started, or 0 if it can not be resumed.
+func resumeOffset(filePath string) (int64, string) {
+	stat, err := os.Stat(partPath(filePath))
+	if err != nil || stat.Size() == 0 {
+		return 0, ""
+	}
+	meta, err := readFileMeta(resumeMetaPath(filePath))
+	if err != nil || meta.validator() == "" {
+		return 0, ""
+	}
+	return stat.Size(), meta.validator()
+}
+
+// parseContentRange parses "bytes first-last/total" and returns first and
+// total, total is -1 if unknown.
+func parseContentRange(s string) (first int64, total int64, err error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	s = strings.TrimPrefix(s, "bytes ")
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	total = -1
+	if t := s[i+1:]; t != "*" {
+		if total, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if s[:i] == "*" {
+		return -1, total, nil
+	}
+	j := strings.IndexByte(s[:i], '-')
+	if j < 0 {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	first, err = strconv.ParseInt(s[:j], 10, 64)
+	return first, total, err
+}
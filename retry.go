@@ -0,0 +1,263 @@
+package httpfile
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed attempt of a transfer is retried.
+type RetryPolicy interface {
+	// Retry is called after the attempt-th retry (0 for the first request)
+	// failed with resp or err, it returns the delay before the next attempt
+	// and false to give up.
+	Retry(attempt int, resp *http.Response, err error) (time.Duration, bool)
+}
+
+// Backoff retries transient network errors, 408, 429 and 5xx responses
+// with exponential backoff and jitter, honoring Retry-After.
+type Backoff struct {
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, 100ms by default.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay including Retry-After, 30s by default.
+	MaxDelay time.Duration
+}
+
+// NewBackoff returns a Backoff with maxRetries and the default delays.
+func NewBackoff(maxRetries int) *Backoff {
+	return &Backoff{MaxRetries: maxRetries}
+}
+
+// Retry implements RetryPolicy.
+func (b *Backoff) Retry(attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if attempt >= b.MaxRetries || !retryable(resp, err) {
+		return 0, false
+	}
+	base, max := b.BaseDelay, b.MaxDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 30 * time.Second
+	}
+	if resp != nil {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if delay > max {
+				delay = max
+			}
+			return delay, true
+		}
+	}
+	delay := max
+	if attempt < 32 && base<<uint(attempt) < max {
+		delay = base << uint(attempt)
+	}
+	// keep half of the delay and randomize the rest
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	return delay, true
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return transient(err)
+	}
+	if resp == nil {
+		return false
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+// transient reports whether err is a network failure that may succeed when
+// tried again, such as a reset connection or a timeout. Errors of the
+// request itself, of a hook or of the certificate of the server are
+// permanent.
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	// *url.Error is a net.Error whatever it wraps
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err
+	}
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &certErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// parseRetryAfter parses delay-seconds or an HTTP-date.
+func parseRetryAfter(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(s); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+	delay := time.Until(t)
+	if delay < 0 {
+		delay = 0
+	}
+	return delay, true
+}
+
+// retrier counts the attempts of one transfer.
+type retrier struct {
+	ctx     context.Context
+	policy  RetryPolicy
+	retries int
+}
+
+func newRetrier(ctx context.Context, policy RetryPolicy) *retrier {
+	return &retrier{ctx: ctx, policy: policy}
+}
+
+// retry reports whether to try again after resp or err, and waits for the
+// backoff delay. resp is drained and closed when retrying, if the context is
+// done meanwhile its error is returned.
+func (r *retrier) retry(resp *http.Response, err error) (bool, error) {
+	if r.policy == nil || r.ctx.Err() != nil {
+		return false, nil
+	}
+	delay, ok := r.policy.Retry(r.retries, resp, err)
+	if !ok {
+		return false, nil
+	}
+	if resp != nil {
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBodySize))
+		resp.Body.Close()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-r.ctx.Done():
+		return false, r.ctx.Err()
+	}
+	r.retries++
+	transferFrom(r.ctx).retried()
+	return true, nil
+}
+
+// do sends the request built by newRequest until it succeeds or the policy
+// gives up. newRequest is called for every attempt, so the body is fresh.
+func (r *retrier) do(client *http.Client, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	for {
+		request, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		request, span := startAttempt(request, r.retries)
+		resp, err := client.Do(request)
+		endSpan(span, resp, err)
+		ok, waitErr := r.retry(resp, err)
+		if waitErr != nil {
+			return nil, waitErr
+		}
+		if !ok {
+			return resp, err
+		}
+	}
+}
+
+// rewinder provides the body of every attempt of an upload, only an
+// io.Seeker can be sent more than once.
+type rewinder struct {
+	body   io.Reader
+	seeker io.Seeker
+	start  int64
+	// size is -1 if unknown.
+	size int64
+}
+
+func newRewinder(body io.Reader) *rewinder {
+	r := &rewinder{body: body, size: -1}
+	// the length of the readers known by http.NewRequest, it is lost once
+	// the body is wrapped
+	switch b := body.(type) {
+	case *bytes.Buffer:
+		r.size = int64(b.Len())
+	case *bytes.Reader:
+		r.size = int64(b.Len())
+	case *strings.Reader:
+		r.size = int64(b.Len())
+	}
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return r
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return r
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return r
+	}
+	if _, err = seeker.Seek(start, io.SeekStart); err != nil {
+		return r
+	}
+	r.seeker, r.start, r.size = seeker, start, end-start
+	return r
+}
+
+func (r *rewinder) rewindable() bool {
+	return r.seeker != nil
+}
+
+// next rewinds the body and returns it with its length, -1 if unknown. The
+// body is not closed by the transport, so it can be sent again.
+func (r *rewinder) next() (io.Reader, int64, error) {
+	if r.seeker == nil {
+		if r.size == 0 {
+			return http.NoBody, 0, nil
+		}
+		return r.body, r.size, nil
+	}
+	if _, err := r.seeker.Seek(r.start, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	if r.size == 0 {
+		return http.NoBody, 0, nil
+	}
+	return ioutil.NopCloser(r.body), r.size, nil
+}
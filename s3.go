@@ -0,0 +1,239 @@
+package httpfile
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// DefaultPartSize is the size of the parts of UploadMultipart, the minimum
+// part size of S3.
+const DefaultPartSize = 5 << 20
+
+// DefaultPartConcurrency is the number of parts uploaded at a time.
+const DefaultPartConcurrency = 4
+
+// ErrMissingPartURL is returned by UploadMultipart when there is no URL for
+// a part.
+var ErrMissingPartURL = errors.New("Missing part URL")
+
+// S3Error is an error returned in the body of a successful
+// CompleteMultipartUpload response.
+type S3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// partCount returns the number of parts of size bytes, an empty file is one
+// empty part.
+func partCount(size, partSize int64) int {
+	if size == 0 {
+		return 1
+	}
+	return int((size + partSize - 1) / partSize)
+}
+
+// partURL returns the presigned URL of part n, starting at 1.
+func (h *Files) partURL(n int) (string, error) {
+	if h.partURLFunc != nil {
+		return h.partURLFunc(n)
+	}
+	if n > len(h.partURLs) {
+		return "", ErrMissingPartURL
+	}
+	return h.partURLs[n-1], nil
+}
+
+// UploadMultipart uploads the file in parts by PUT to the presigned URLs of
+// SetPartURLs or SetPartURLFunc, and completes the upload by POST of the
+// CompleteMultipartUpload XML to the target URL. Each part is retried by
+// the retry policy, if the upload fails it is aborted at the URL of
+// SetAbortURL.
+func (h *Files) UploadMultipart() *Response {
+	return h.transfer("UploadMultipart", http.MethodPut, h.uploadMultipart)
+}
+
+func (h *Files) uploadMultipart() *Response {
+	res := h.checkUpload()
+	if res.err == nil && h.filePath == "" {
+		res.err = ErrEmptyFilePath
+	}
+	if res.err != nil {
+		return res
+	}
+	file, err := os.Open(h.filePath)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		res.err = err
+		return res
+	}
+	partSize := h.partSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	parts := make([]completedPart, partCount(stat.Size(), partSize))
+	if res.err = h.uploadParts(file, stat.Size(), partSize, parts); res.err == nil {
+		res.resp, res.err = h.completeMultipart(parts)
+	}
+	if res.err != nil && h.abortURL != "" {
+		h.abortMultipart()
+	}
+	return res
+}
+
+// uploadParts uploads the parts of file concurrently, a failed part cancels
+// the others.
+func (h *Files) uploadParts(file *os.File, size, partSize int64, parts []completedPart) error {
+	concurrency := h.partConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultPartConcurrency
+	}
+	p := newProgress(h.progress, h.interval)
+	p.reset(0, size)
+	t := newThrottle(h.rateLimit, h.limiter)
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	numbers := make(chan int, len(parts))
+	for i := range parts {
+		numbers <- i + 1
+	}
+	close(numbers)
+	errs := make([]error, len(parts))
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency && i < len(parts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range numbers {
+				first := int64(n-1) * partSize
+				length := size - first
+				if length > partSize {
+					length = partSize
+				}
+				etag, err := h.uploadPart(ctx, io.NewSectionReader(file, first, length), n, p, t)
+				if err != nil {
+					errs[n-1] = err
+					cancel()
+					return
+				}
+				parts[n-1] = completedPart{PartNumber: n, ETag: etag}
+			}
+		}()
+	}
+	wg.Wait()
+	// prefer the error that caused the cancellation
+	var err error
+	for _, e := range errs {
+		if e != nil && (err == nil || errors.Is(err, context.Canceled)) {
+			err = e
+		}
+	}
+	if err == nil {
+		p.done()
+	}
+	return err
+}
+
+// uploadPart uploads part n and returns its ETag.
+func (h *Files) uploadPart(ctx context.Context, part *io.SectionReader, n int, p *progress, t throttle) (etag string, err error) {
+	ctx, span := startSpan(ctx, "httpfile.part")
+	span.SetAttribute("httpfile.part", n)
+	defer func() { endSpan(span, nil, err) }()
+	partURL, err := h.partURL(n)
+	if err != nil {
+		return "", err
+	}
+	resp, err := newRetrier(ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		var body io.Reader = http.NoBody
+		if part.Size() > 0 {
+			body = p.reader(t.reader(ctx, countSent(ctx, io.NewSectionReader(part, 0, part.Size()))))
+		}
+		request, err := newRequest(ctx, http.MethodPut, partURL, body, "", h.header)
+		if err == nil {
+			request.ContentLength = part.Size()
+		}
+		return request, err
+	})
+	if err != nil {
+		return "", fmt.Errorf("part %d: %w", n, err)
+	}
+	defer resp.Body.Close()
+	if !isSuccess(resp.StatusCode) {
+		return "", fmt.Errorf("part %d: %w", n, newHTTPError(resp))
+	}
+	etag = resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("part %d: missing ETag", n)
+	}
+	return etag, nil
+}
+
+// completeMultipart sends the ETags of the parts. S3 may report an error in
+// the body of a 200 response, it is returned as *S3Error.
+func (h *Files) completeMultipart(parts []completedPart) (*http.Response, error) {
+	body, err := xml.Marshal(completeMultipartUpload{Parts: parts})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		return newRequest(h.ctx, http.MethodPost, h.targetURL, bytes.NewReader(body), "application/xml", h.header)
+	})
+	if err != nil {
+		return resp, err
+	}
+	if !isSuccess(resp.StatusCode) {
+		err = newHTTPError(resp)
+		resp.Body.Close()
+		return resp, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return resp, err
+	}
+	// the body stays readable from the Response
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	s3Err := &S3Error{}
+	if xml.Unmarshal(data, s3Err) == nil && s3Err.Code != "" {
+		return resp, s3Err
+	}
+	return resp, nil
+}
+
+// abortMultipart deletes the parts of a failed upload. It is sent even if
+// the context is done, so the parts are not left behind.
+func (h *Files) abortMultipart() {
+	request, err := newRequest(context.Background(), http.MethodDelete, h.abortURL, nil, "", h.header)
+	if err != nil {
+		return
+	}
+	if resp, err := h.httpClient().Do(request); err == nil {
+		resp.Body.Close()
+	}
+}
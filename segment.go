@@ -0,0 +1,199 @@
+package httpfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type byteRange struct {
+	first, last int64
+}
+
+// splitRanges splits size bytes into n contiguous ranges.
+func splitRanges(size int64, n int) []byteRange {
+	if int64(n) > size {
+		n = int(size)
+	}
+	ranges := make([]byteRange, 0, n)
+	step := size / int64(n)
+	var first int64
+	for i := 0; i < n; i++ {
+		last := first + step - 1
+		if i == n-1 {
+			last = size - 1
+		}
+		ranges = append(ranges, byteRange{first, last})
+		first = last + 1
+	}
+	return ranges
+}
+
+// offsetWriter writes to w sequentially starting at offset.
+type offsetWriter struct {
+	w      io.WriterAt
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.offset)
+	o.offset += int64(n)
+	return n, err
+}
+
+// downloadSegments fetches the target in h.segments concurrent ranges. It
+// returns nil if the server does not support range requests, so the caller
+// can fall back to a single stream. The HEAD request is conditional on cache.
+func (h *Files) downloadSegments(cache *fileMeta) *Response {
+	head := h.checkDownload()
+	head.resp, head.err = newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		request, err := h.newRequest(http.MethodHead, nil, "")
+		if err == nil {
+			cache.setConditional(request)
+		}
+		return request, err
+	})
+	if head.err != nil {
+		return nil
+	}
+	head.Close()
+	if cache != nil && head.resp.StatusCode == http.StatusNotModified {
+		return head
+	}
+	size := head.resp.ContentLength
+	if head.resp.StatusCode != http.StatusOK || size <= 0 ||
+		!strings.Contains(head.resp.Header.Get("Accept-Ranges"), "bytes") {
+		return nil
+	}
+	res := head
+	named := h.filePath == ""
+	if named {
+		if err := h.nameDownload(res); err != nil {
+			if err != errSkipped {
+				res.err = err
+			}
+			return res
+		}
+	}
+	validator := newFileMeta(res.resp.Header).validator()
+	out, err := createDownloadFile(h.filePath)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	if named {
+		out.collision = h.collision
+	}
+	if err = out.Truncate(size); err != nil {
+		out.Abort()
+		res.err = err
+		return res
+	}
+
+	p := newProgress(h.progress, h.interval)
+	p.reset(0, size)
+	t := newThrottle(h.rateLimit, h.limiter)
+	// a failed segment cancels the others
+	ctx, cancel := context.WithCancel(h.ctx)
+	defer cancel()
+	ranges := splitRanges(size, h.segments)
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func(i int, r byteRange) {
+			defer wg.Done()
+			if errs[i] = h.downloadRange(ctx, out, r, validator, p, t); errs[i] != nil {
+				cancel()
+			}
+		}(i, r)
+	}
+	wg.Wait()
+	// prefer the error that caused the cancellation
+	for _, err := range errs {
+		if err != nil && (res.err == nil || errors.Is(res.err, context.Canceled)) {
+			res.err = err
+		}
+	}
+	if res.err != nil {
+		out.Abort()
+		return res
+	}
+	// segments arrive out of order, the file is hashed afterwards
+	v, err := newVerifier(h.checksum, res.resp, true, !h.skipServerDigests)
+	if err == nil {
+		err = v.hashFile(out.Name(), -1)
+	}
+	if err == nil {
+		res.digests = v.digests()
+		err = v.verify()
+	}
+	if err != nil {
+		res.err = err
+		out.Abort()
+		return res
+	}
+	if h.commit(res, out) {
+		p.done()
+	}
+	return res
+}
+
+// downloadRange fetches r into out, a retried attempt continues after the
+// bytes already written.
+func (h *Files) downloadRange(ctx context.Context, out io.WriterAt, r byteRange, validator string, p *progress, t throttle) (err error) {
+	ctx, span := startSpan(ctx, "httpfile.segment")
+	span.SetAttribute("httpfile.range", fmt.Sprintf("bytes=%d-%d", r.first, r.last))
+	defer func() { endSpan(span, nil, err) }()
+	retry := newRetrier(ctx, h.retry)
+	for {
+		resp, err := retry.do(h.httpClient(), func() (*http.Request, error) {
+			request, err := h.newRequest(http.MethodGet, nil, "")
+			if err != nil {
+				return nil, err
+			}
+			request = request.WithContext(ctx)
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", r.first, r.last))
+			if validator != "" {
+				request.Header.Set("If-Range", validator)
+			}
+			return request, nil
+		})
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			err = newHTTPError(resp)
+			resp.Body.Close()
+			return fmt.Errorf("segment %d-%d: %w", r.first, r.last, err)
+		}
+		first, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+		if err == nil && first != r.first {
+			err = ErrRangeMismatch
+		}
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+		n, err := io.Copy(&offsetWriter{w: out, offset: r.first}, p.reader(t.reader(ctx, countReceived(ctx, io.LimitReader(resp.Body, r.last-r.first+1)))))
+		resp.Body.Close()
+		r.first += n
+		if err == nil && r.first <= r.last {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			return nil
+		}
+		ok, waitErr := retry.retry(nil, err)
+		if waitErr != nil {
+			return waitErr
+		}
+		if !ok {
+			return err
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package httpfile
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	streamingPayload = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	emptySHA256      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// DefaultSigV4ChunkSize is the chunk size of streaming signed bodies.
+	DefaultSigV4ChunkSize = 64 << 10
+)
+
+// SigV4 signs requests with AWS Signature Version 4 for S3 and compatible
+// stores. Bodies that can be read again, such as strings and bytes, are
+// hashed. Others are signed chunk by chunk in aws-chunked encoding if their
+// length is known, and sent as UNSIGNED-PAYLOAD otherwise.
+type SigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken of temporary credentials, optional.
+	SessionToken string
+	Region       string
+	// Service is "s3" by default.
+	Service string
+	// UnsignedPayload sends bodies that can not be read again as
+	// UNSIGNED-PAYLOAD instead of signing their chunks.
+	UnsignedPayload bool
+	// ChunkSize of streaming signed bodies, DefaultSigV4ChunkSize by default.
+	ChunkSize int
+
+	now func() time.Time
+}
+
+// Authorize implements Authorizer.
+func (s *SigV4) Authorize(request *http.Request) error {
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	amzDate := now().UTC().Format("20060102T150405Z")
+	request.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+	payload, err := s.payloadHash(request)
+	if err != nil {
+		return err
+	}
+	chunkSize := s.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultSigV4ChunkSize
+	}
+	if payload == streamingPayload {
+		request.Header.Set("Content-Encoding", "aws-chunked")
+		request.Header.Set("X-Amz-Decoded-Content-Length", strconv.FormatInt(request.ContentLength, 10))
+		request.ContentLength = chunkedLength(request.ContentLength, chunkSize)
+	}
+	request.Header.Set("X-Amz-Content-Sha256", payload)
+
+	canonical, signedHeaders := canonicalRequest(request, payload, nil)
+	scope := s.scope(amzDate)
+	key := s.signingKey(amzDate)
+	signature := hex.EncodeToString(hmacSHA256(key, []byte(stringToSign(sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonical))))))
+	request.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.AccessKeyID, scope, signedHeaders, signature))
+	if payload == streamingPayload {
+		request.Body = &chunkSigner{
+			body:      request.Body,
+			chunk:     make([]byte, chunkSize),
+			key:       key,
+			amzDate:   amzDate,
+			scope:     scope,
+			signature: signature,
+		}
+	}
+	return nil
+}
+
+func (s *SigV4) service() string {
+	if s.Service == "" {
+		return "s3"
+	}
+	return s.Service
+}
+
+func (s *SigV4) scope(amzDate string) string {
+	return strings.Join([]string{amzDate[:8], s.Region, s.service(), "aws4_request"}, "/")
+}
+
+func (s *SigV4) signingKey(amzDate string) []byte {
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), []byte(amzDate[:8]))
+	key = hmacSHA256(key, []byte(s.Region))
+	key = hmacSHA256(key, []byte(s.service()))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+// payloadHash returns the x-amz-content-sha256 of the body, it is kept if
+// already set.
+func (s *SigV4) payloadHash(request *http.Request) (string, error) {
+	if payload := request.Header.Get("X-Amz-Content-Sha256"); payload != "" {
+		return payload, nil
+	}
+	if request.Body == nil || request.Body == http.NoBody {
+		return emptySHA256, nil
+	}
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		hash := sha256.New()
+		if _, err = io.Copy(hash, body); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+	if s.UnsignedPayload || request.ContentLength <= 0 {
+		return unsignedPayload, nil
+	}
+	return streamingPayload, nil
+}
+
+// canonicalRequest returns the canonical request and its signed headers.
+// All headers but Authorization and User-Agent are signed if signed is nil.
+func canonicalRequest(request *http.Request, payload string, signed []string) (string, string) {
+	host := request.Host
+	if host == "" {
+		host = request.URL.Host
+	}
+	values := map[string]string{"host": host}
+	if request.ContentLength > 0 {
+		values["content-length"] = strconv.FormatInt(request.ContentLength, 10)
+	}
+	for k, v := range request.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" {
+			continue
+		}
+		trimmed := make([]string, len(v))
+		for i := range v {
+			trimmed[i] = strings.Join(strings.Fields(v[i]), " ")
+		}
+		values[k] = strings.Join(trimmed, ",")
+	}
+	if signed == nil {
+		for k := range values {
+			signed = append(signed, k)
+		}
+		sort.Strings(signed)
+	}
+	var headers strings.Builder
+	for _, k := range signed {
+		headers.WriteString(k + ":" + values[k] + "\n")
+	}
+	path := request.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	return strings.Join([]string{
+		request.Method,
+		uriEncode(path, false),
+		canonicalQuery(request),
+		headers.String(),
+		strings.Join(signed, ";"),
+		payload,
+	}, "\n"), strings.Join(signed, ";")
+}
+
+func canonicalQuery(request *http.Request) string {
+	query := request.URL.Query()
+	pairs := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes all but the unreserved characters of RFC 3986, and "/"
+// unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func stringToSign(algorithm, amzDate, scope, hash string) string {
+	return strings.Join([]string{algorithm, amzDate, scope, hash}, "\n")
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chunkSignature signs a chunk of a streaming body after the signature of
+// the previous chunk, or of the request for the first one.
+func chunkSignature(key []byte, amzDate, scope, previous string, chunk []byte) string {
+	toSign := stringToSign(sigV4Algorithm+"-PAYLOAD", amzDate, scope, previous+"\n"+emptySHA256+"\n"+sha256Hex(chunk))
+	return hex.EncodeToString(hmacSHA256(key, []byte(toSign)))
+}
+
+// chunkedLength returns the length of size bytes in aws-chunked encoding.
+func chunkedLength(size int64, chunkSize int) int64 {
+	frame := func(n int64) int64 {
+		return int64(len(strconv.FormatInt(n, 16))) + int64(len(";chunk-signature=")) + 64 + 2 + n + 2
+	}
+	full := size / int64(chunkSize)
+	length := full * frame(int64(chunkSize))
+	if rest := size % int64(chunkSize); rest > 0 {
+		length += frame(rest)
+	}
+	return length + frame(0)
+}
+
+// chunkSigner encodes a body in signed aws-chunked encoding.
+type chunkSigner struct {
+	body      io.ReadCloser
+	chunk     []byte
+	key       []byte
+	amzDate   string
+	scope     string
+	signature string
+	buf       []byte
+	done      bool
+}
+
+func (c *chunkSigner) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(c.body, c.chunk)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		c.signature = chunkSignature(c.key, c.amzDate, c.scope, c.signature, c.chunk[:n])
+		c.buf = append(c.buf, fmt.Sprintf("%x;chunk-signature=%s\r\n", n, c.signature)...)
+		c.buf = append(c.buf, c.chunk[:n]...)
+		c.buf = append(c.buf, "\r\n"...)
+		c.done = n == 0
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *chunkSigner) Close() error {
+	return c.body.Close()
+}
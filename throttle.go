@@ -0,0 +1,120 @@
+package httpfile
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// maxBurst caps the bytes a Limiter allows at once.
+const maxBurst = 64 << 10
+
+// Limiter caps the bytes per second of all transfers sharing it, a nil
+// *Limiter does not limit.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter of bytesPerSecond, or nil if it is not
+// positive.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := bytesPerSecond / 10
+	if burst < 1 {
+		burst = 1
+	}
+	if burst > maxBurst {
+		burst = maxBurst
+	}
+	return &Limiter{rate: float64(bytesPerSecond), burst: int(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n bytes from the limiter and blocks until they are allowed.
+// Concurrent transfers queue up by borrowing from future tokens.
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttle is the limiters of one transfer, its own rate and a shared one.
+type throttle []*Limiter
+
+func newThrottle(bytesPerSecond int64, shared *Limiter) throttle {
+	var t throttle
+	for _, l := range []*Limiter{NewLimiter(bytesPerSecond), shared} {
+		if l != nil {
+			t = append(t, l)
+		}
+	}
+	return t
+}
+
+// reader limits the bytes read from r, it closes r if it is an io.Closer.
+func (t throttle) reader(ctx context.Context, r io.Reader) io.Reader {
+	if len(t) == 0 {
+		return r
+	}
+	burst := t[0].burst
+	for _, l := range t[1:] {
+		if l.burst < burst {
+			burst = l.burst
+		}
+	}
+	return &throttleReader{ctx: ctx, r: r, t: t, burst: burst}
+}
+
+type throttleReader struct {
+	ctx   context.Context
+	r     io.Reader
+	t     throttle
+	burst int
+}
+
+func (r *throttleReader) Read(b []byte) (int, error) {
+	if len(b) > r.burst {
+		b = b[:r.burst]
+	}
+	n, err := r.r.Read(b)
+	for _, l := range r.t {
+		if werr := l.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *throttleReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package httpfile
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -14,13 +15,59 @@ var defaultHTTPClient = &http.Client{}
 var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
 
 // CreateFormFile is a convenience wrapper around CreatePart. It creates
-// a new form-data header with the provided field name and file name.
-func createFormFile(w *multipart.Writer, filename string, contentType string) (io.Writer, error) {
+// a new form-data header with the provided field name and file name, the
+// field name is "file" if empty.
+func createFormFile(w *multipart.Writer, fieldName, filename string, contentType string) (io.Writer, error) {
+	if fieldName == "" {
+		fieldName = "file"
+	}
 	h := make(textproto.MIMEHeader)
-	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, escapeQuotes(filename)))
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(fieldName), escapeQuotes(filename)))
 	h.Set("Content-Type", contentType)
 	return w.CreatePart(h)
 }
+
+// newRequest creates a request with header, which overrides contentType.
+func newRequest(ctx context.Context, method, targetURL string, body io.Reader, contentType string, header map[string]string) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, targetURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+	for k, v := range header {
+		request.Header.Set(k, v)
+	}
+	return request, nil
+}
+
+// firstHeader returns the optional header argument of HTTPFile methods.
+func firstHeader(header []map[string]string) map[string]string {
+	if len(header) > 0 {
+		return header[0]
+	}
+	return nil
+}
+
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// isSuccess reports whether statusCode is 2xx.
+func isSuccess(statusCode int) bool {
+	return statusCode >= 200 && statusCode < 300
+}
+
 func escapeQuotes(s string) string {
 	return quoteEscaper.Replace(s)
 }
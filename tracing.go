@@ -0,0 +1,190 @@
+package httpfile
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Tracer starts the spans of transfers, it can be adapted to OpenTelemetry
+// or another tracing library. Every operation of Files and HTTPFile is a
+// span named "httpfile." followed by the method, such as
+// "httpfile.Download", with child spans "httpfile.attempt" for every
+// request, "httpfile.segment" for the ranges of a segmented download and
+// "httpfile.part" for the parts of a multipart upload.
+type Tracer interface {
+	// Start starts a span as a child of the span of ctx, if any, and returns
+	// a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation.
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+	// SpanContext identifies the span, it is sent to the server in the W3C
+	// traceparent header.
+	SpanContext() SpanContext
+}
+
+// SpanContext identifies a span in a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the trace and span IDs are set.
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// TraceParent returns the W3C traceparent header of the span.
+func (c SpanContext) TraceParent() string {
+	var flags byte
+	if c.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(c.TraceID[:]), hex.EncodeToString(c.SpanID[:]), flags)
+}
+
+type spanKey struct{}
+
+// spanFrom returns the span of ctx, nil if there is none.
+func spanFrom(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// noopSpan is the span of transfers without a Tracer.
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) RecordError(err error)                      {}
+func (noopSpan) End()                                       {}
+func (noopSpan) SpanContext() SpanContext                   { return SpanContext{} }
+
+// startSpan starts a child span of the transfer of ctx, a no-op span if it
+// has no Tracer.
+func startSpan(ctx context.Context, name string) (context.Context, Span) {
+	t := transferFrom(ctx)
+	if t == nil || t.tracer == nil {
+		return ctx, noopSpan{}
+	}
+	ctx, span := t.tracer.Start(ctx, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// setTraceParent propagates the span of the context of request.
+func setTraceParent(request *http.Request) {
+	if span := spanFrom(request.Context()); span != nil && span.SpanContext().IsValid() {
+		request.Header.Set("traceparent", span.SpanContext().TraceParent())
+	}
+}
+
+// startAttempt starts the span of an attempt of request, counting from 0,
+// and propagates it to the server.
+func startAttempt(request *http.Request, attempt int) (*http.Request, Span) {
+	ctx, span := startSpan(request.Context(), "httpfile.attempt")
+	span.SetAttribute("http.request.method", request.Method)
+	span.SetAttribute("httpfile.attempt", attempt)
+	request = request.WithContext(ctx)
+	setTraceParent(request)
+	return request, span
+}
+
+// endSpan records the response or error of a request and ends span.
+func endSpan(span Span, resp *http.Response, err error) {
+	if resp != nil {
+		span.SetAttribute("http.response.status_code", resp.StatusCode)
+	}
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// SpanRecorder is a Tracer keeping the ended spans in memory, such as for
+// tests.
+type SpanRecorder struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// NewSpanRecorder returns an empty SpanRecorder.
+func NewSpanRecorder() *SpanRecorder {
+	return &SpanRecorder{}
+}
+
+// RecordedSpan is a span of a SpanRecorder.
+type RecordedSpan struct {
+	recorder   *SpanRecorder
+	mu         sync.Mutex
+	Name       string
+	Context    SpanContext
+	Parent     SpanContext
+	Attributes map[string]interface{}
+	Err        error
+	Start      time.Time
+	EndTime    time.Time
+}
+
+// Start implements Tracer.
+func (r *SpanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	span := &RecordedSpan{
+		recorder:   r,
+		Name:       name,
+		Attributes: make(map[string]interface{}),
+		Start:      time.Now(),
+	}
+	span.Context.Sampled = true
+	if parent := spanFrom(ctx); parent != nil {
+		span.Parent = parent.SpanContext()
+		span.Context.TraceID = span.Parent.TraceID
+	} else {
+		rand.Read(span.Context.TraceID[:])
+	}
+	rand.Read(span.Context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Spans returns the ended spans in the order they ended.
+func (r *SpanRecorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RecordedSpan(nil), r.spans...)
+}
+
+// SetAttribute implements Span.
+func (s *RecordedSpan) SetAttribute(key string, value interface{}) {
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// RecordError implements Span.
+func (s *RecordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	s.Err = err
+	s.mu.Unlock()
+}
+
+// End implements Span.
+func (s *RecordedSpan) End() {
+	s.mu.Lock()
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	s.recorder.mu.Lock()
+	s.recorder.spans = append(s.recorder.spans, s)
+	s.recorder.mu.Unlock()
+}
+
+// SpanContext implements Span.
+func (s *RecordedSpan) SpanContext() SpanContext {
+	return s.Context
+}
@@ -0,0 +1,222 @@
+package httpfile
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// observers receive the transfers of Files and HTTPFile.
+type observers struct {
+	logger  *slog.Logger
+	metrics MetricsCollector
+	tracer  Tracer
+}
+
+// transfer collects the statistics of one operation, such as a download
+// with all its retries, and reports them when it is done. A nil *transfer
+// does nothing.
+type transfer struct {
+	observers
+	operation string
+	method    string
+	url       string
+	host      string
+	header    map[string]string
+	filePath  string
+	start     time.Time
+	span      Span
+	retries   int64
+	sent      int64
+	received  int64
+}
+
+type transferKey struct{}
+
+// newTransfer starts operation, it returns nil if there are no observers.
+func newTransfer(o observers, operation, method, targetURL string, header map[string]string, filePath string) *transfer {
+	if o.logger == nil && o.metrics == nil && o.tracer == nil {
+		return nil
+	}
+	t := &transfer{
+		observers: o,
+		operation: operation,
+		method:    method,
+		url:       targetURL,
+		header:    header,
+		filePath:  filePath,
+		start:     time.Now(),
+		span:      noopSpan{},
+	}
+	if u, err := url.Parse(targetURL); err == nil {
+		t.host = u.Host
+	}
+	if t.metrics != nil {
+		t.metrics.TransferStarted(t.operation, t.host)
+	}
+	return t
+}
+
+// context returns ctx carrying t, so the requests of the transfer count
+// their retries and bytes. It starts the span of the transfer.
+func (t *transfer) context(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	if t.tracer != nil {
+		ctx, t.span = t.tracer.Start(ctx, "httpfile."+t.operation)
+		ctx = context.WithValue(ctx, spanKey{}, t.span)
+		t.span.SetAttribute("http.request.method", t.method)
+		t.span.SetAttribute("server.address", t.host)
+	}
+	return context.WithValue(ctx, transferKey{}, t)
+}
+
+// transferFrom returns the transfer of ctx, nil if there is none.
+func transferFrom(ctx context.Context) *transfer {
+	t, _ := ctx.Value(transferKey{}).(*transfer)
+	return t
+}
+
+func (t *transfer) retried() {
+	if t != nil {
+		atomic.AddInt64(&t.retries, 1)
+	}
+}
+
+// countSent counts the bytes of a request body read from r for the
+// transfer of ctx, it closes r if it is an io.Closer.
+func countSent(ctx context.Context, r io.Reader) io.Reader {
+	t := transferFrom(ctx)
+	if t == nil {
+		return r
+	}
+	return &countReader{r: r, n: &t.sent}
+}
+
+// countReceived counts the bytes of a response body read from r for the
+// transfer of ctx, it closes r if it is an io.Closer.
+func countReceived(ctx context.Context, r io.Reader) io.Reader {
+	t := transferFrom(ctx)
+	if t == nil {
+		return r
+	}
+	return &countReader{r: r, n: &t.received}
+}
+
+type countReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (r *countReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	atomic.AddInt64(r.n, int64(n))
+	return n, err
+}
+
+func (r *countReader) Close() error {
+	if c, ok := r.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// done reports the transfer with the final status code, 0 if there is no
+// response, the file path if it changed and the verified digests.
+func (t *transfer) done(ctx context.Context, status int, filePath string, digests map[string]string, err error) {
+	if t == nil {
+		return
+	}
+	if filePath != "" {
+		t.filePath = filePath
+	}
+	stats := TransferStats{
+		Operation:     t.operation,
+		Host:          t.host,
+		Status:        status,
+		BytesSent:     atomic.LoadInt64(&t.sent),
+		BytesReceived: atomic.LoadInt64(&t.received),
+		Duration:      time.Since(t.start),
+		Retries:       int(atomic.LoadInt64(&t.retries)),
+		Err:           err,
+	}
+	if t.logger != nil {
+		t.log(ctx, stats, formatDigests(digests))
+	}
+	if t.metrics != nil {
+		t.metrics.TransferDone(stats)
+	}
+	if stats.Status > 0 {
+		t.span.SetAttribute("http.response.status_code", stats.Status)
+	}
+	t.span.SetAttribute("httpfile.bytes_sent", stats.BytesSent)
+	t.span.SetAttribute("httpfile.bytes_received", stats.BytesReceived)
+	t.span.SetAttribute("httpfile.retries", stats.Retries)
+	if err != nil {
+		t.span.RecordError(err)
+	}
+	t.span.End()
+}
+
+// formatDigests formats digests as "algorithm:hex" sorted by algorithm.
+func formatDigests(digests map[string]string) string {
+	pairs := make([]string, 0, len(digests))
+	for algorithm, hex := range digests {
+		pairs = append(pairs, algorithm+":"+hex)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// responded reports an operation of HTTPFile with the response resp.
+func (t *transfer) responded(ctx context.Context, resp *http.Response, err error) {
+	var status int
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	t.done(ctx, status, "", nil, err)
+}
+
+// uploaded reports an upload of HTTPFile.
+func (t *transfer) uploaded(ctx context.Context, res *UploadResponse, err error) {
+	var status int
+	if res != nil {
+		status = res.StatusCode
+	}
+	t.done(ctx, status, "", nil, err)
+}
+
+// downloaded reports a download of HTTPFile to savePath.
+func (t *transfer) downloaded(ctx context.Context, res *DownloadResponse, savePath string, err error) {
+	var status int
+	var digests map[string]string
+	if res != nil {
+		status, digests = res.StatusCode, res.Digests
+	}
+	t.done(ctx, status, savePath, digests, err)
+}
+
+// transfer runs fn as operation, reported when it is done.
+func (h *Files) transfer(operation, method string, fn func() *Response) *Response {
+	t := newTransfer(h.observers, operation, method, h.targetURL, h.header, h.filePath)
+	if t == nil {
+		return fn()
+	}
+	ctx := h.ctx
+	h.ctx = t.context(ctx)
+	res := fn()
+	h.ctx = ctx
+	var status int
+	if res.resp != nil {
+		status = res.resp.StatusCode
+	}
+	t.done(ctx, status, res.filePath, res.digests, res.Error())
+	return res
+}
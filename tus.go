@@ -0,0 +1,434 @@
+package httpfile
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// TusResumable is the version of the tus protocol spoken by UploadTus.
+const TusResumable = "1.0.0"
+
+// ErrTusUploadNotFound is returned by TerminateTus when no upload of the
+// file is recorded in the store.
+var ErrTusUploadNotFound = errors.New("tus upload not found")
+
+// ErrTusProtocol is wrapped by the errors of invalid tus responses, such as
+// a missing Location, they are not retried.
+var ErrTusProtocol = errors.New("tus protocol error")
+
+// TusStore persists the upload URLs of tus uploads by a fingerprint of the
+// file, so a restarted process can continue them.
+type TusStore interface {
+	Get(fingerprint string) (uploadURL string, ok bool)
+	Set(fingerprint, uploadURL string) error
+	Delete(fingerprint string) error
+}
+
+// NewTusMemoryStore returns a TusStore kept in memory.
+func NewTusMemoryStore() TusStore {
+	return &tusMemoryStore{urls: make(map[string]string)}
+}
+
+type tusMemoryStore struct {
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+func (s *tusMemoryStore) Get(fingerprint string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploadURL, ok := s.urls[fingerprint]
+	return uploadURL, ok
+}
+
+func (s *tusMemoryStore) Set(fingerprint, uploadURL string) error {
+	s.mu.Lock()
+	s.urls[fingerprint] = uploadURL
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *tusMemoryStore) Delete(fingerprint string) error {
+	s.mu.Lock()
+	delete(s.urls, fingerprint)
+	s.mu.Unlock()
+	return nil
+}
+
+// NewTusFileStore returns a TusStore persisted as JSON in path, it is
+// created on the first upload.
+func NewTusFileStore(path string) TusStore {
+	return &tusFileStore{path: path}
+}
+
+type tusFileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *tusFileStore) load() (map[string]string, error) {
+	urls := make(map[string]string)
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return urls, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return urls, json.Unmarshal(data, &urls)
+}
+
+func (s *tusFileStore) save(urls map[string]string) error {
+	data, err := json.Marshal(urls)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+func (s *tusFileStore) Get(fingerprint string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls, err := s.load()
+	if err != nil {
+		return "", false
+	}
+	uploadURL, ok := urls[fingerprint]
+	return uploadURL, ok
+}
+
+func (s *tusFileStore) Set(fingerprint, uploadURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls, err := s.load()
+	if err != nil {
+		return err
+	}
+	urls[fingerprint] = uploadURL
+	return s.save(urls)
+}
+
+func (s *tusFileStore) Delete(fingerprint string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	urls, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := urls[fingerprint]; !ok {
+		return nil
+	}
+	delete(urls, fingerprint)
+	return s.save(urls)
+}
+
+// tusFingerprint identifies an upload of a file to an endpoint, a modified
+// file is uploaded anew.
+func tusFingerprint(endpoint, filePath string, stat os.FileInfo) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		filePath = abs
+	}
+	return fmt.Sprintf("%s %s %d %d", endpoint, filePath, stat.Size(), stat.ModTime().UnixNano())
+}
+
+// encodeTusMetadata encodes Upload-Metadata, sorted by key.
+func encodeTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+" "+base64.StdEncoding.EncodeToString([]byte(metadata[k])))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// tusUpload is the state of one UploadTus call.
+type tusUpload struct {
+	h           *Files
+	file        *os.File
+	size        int64
+	fingerprint string
+	uploadURL   string
+	// offset is -1 until it is known from the server.
+	offset int64
+	// conflict is the offset of the last PATCH rejected by 409, -1 if none.
+	conflict int64
+	last     *http.Response
+	retry    *retrier
+	p        *progress
+	t        throttle
+}
+
+// run creates or resumes the upload and sends the file. Failed requests
+// are retried by the retry policy, the offset is queried again before
+// sending on. Invalid responses are returned as ErrTusProtocol at once.
+func (u *tusUpload) run() error {
+	u.retry = newRetrier(u.h.ctx, u.h.retry)
+	for {
+		var resp *http.Response
+		var err error
+		switch {
+		case u.uploadURL == "":
+			resp, err = u.create()
+		case u.offset < 0:
+			resp, err = u.head()
+		case u.offset < u.size:
+			resp, err = u.patch()
+		default:
+			u.p.done()
+			if u.h.tusStore != nil {
+				return u.h.tusStore.Delete(u.fingerprint)
+			}
+			return nil
+		}
+		if resp == nil && err == nil {
+			continue
+		}
+		if errors.Is(err, ErrTusProtocol) {
+			return err
+		}
+		ok, waitErr := u.retry.retry(resp, err)
+		if waitErr != nil {
+			return waitErr
+		}
+		if !ok {
+			if err == nil {
+				u.last = resp
+				err = newHTTPError(resp)
+				resp.Body.Close()
+			}
+			return err
+		}
+		u.offset = -1
+	}
+}
+
+// send sends a tus request with a body of size bytes.
+func (u *tusUpload) send(method, targetURL string, body io.Reader, size int64, header map[string]string) (*http.Response, error) {
+	request, err := newRequest(u.h.ctx, method, targetURL, body, "", u.h.header)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Tus-Resumable", TusResumable)
+	for k, v := range header {
+		request.Header.Set(k, v)
+	}
+	request.ContentLength = size
+	request, span := startAttempt(request, u.retry.retries)
+	resp, err := u.h.httpClient().Do(request)
+	endSpan(span, resp, err)
+	return resp, err
+}
+
+// create creates the upload with its length and metadata.
+func (u *tusUpload) create() (*http.Response, error) {
+	metadata := map[string]string{
+		"filename": filepath.Base(u.file.Name()),
+		"filetype": fileContentType(u.file.Name()),
+	}
+	for k, v := range u.h.tusMetadata {
+		metadata[k] = v
+	}
+	resp, err := u.send(http.MethodPost, u.h.targetURL, nil, 0, map[string]string{
+		"Upload-Length":   strconv.FormatInt(u.size, 10),
+		"Upload-Metadata": encodeTusMetadata(metadata),
+	})
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		return resp, err
+	}
+	resp.Body.Close()
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil || resp.Header.Get("Location") == "" {
+		return nil, fmt.Errorf("%w: invalid Location %q", ErrTusProtocol, resp.Header.Get("Location"))
+	}
+	u.last = resp
+	u.uploadURL = location.String()
+	u.offset = 0
+	if u.h.tusStore != nil {
+		return nil, u.h.tusStore.Set(u.fingerprint, u.uploadURL)
+	}
+	return nil, nil
+}
+
+// head queries the offset of the upload, an upload unknown to the server is
+// created again.
+func (u *tusUpload) head() (*http.Response, error) {
+	resp, err := u.send(http.MethodHead, u.uploadURL, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusNotFound, http.StatusGone, http.StatusForbidden:
+		resp.Body.Close()
+		u.uploadURL = ""
+		if u.h.tusStore != nil {
+			return nil, u.h.tusStore.Delete(u.fingerprint)
+		}
+		return nil, nil
+	}
+	if !isSuccess(resp.StatusCode) {
+		return resp, nil
+	}
+	resp.Body.Close()
+	return nil, u.setOffset(resp)
+}
+
+// patch sends the next chunk from the current offset. A 409 Conflict means
+// the offset is out of sync, it is queried again by HEAD, unless the PATCH
+// from the queried offset is rejected as well.
+func (u *tusUpload) patch() (*http.Response, error) {
+	n := u.size - u.offset
+	if u.h.tusChunkSize > 0 && n > u.h.tusChunkSize {
+		n = u.h.tusChunkSize
+	}
+	header := map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.FormatInt(u.offset, 10),
+	}
+	if u.h.tusChecksum != "" {
+		hash := newHash(u.h.tusChecksum)
+		if _, err := io.Copy(hash, io.NewSectionReader(u.file, u.offset, n)); err != nil {
+			return nil, err
+		}
+		header["Upload-Checksum"] = u.h.tusChecksum + " " + base64.StdEncoding.EncodeToString(hash.Sum(nil))
+	}
+	u.p.reset(u.offset, u.size)
+	body := u.p.reader(u.t.reader(u.h.ctx, countSent(u.h.ctx, io.NewSectionReader(u.file, u.offset, n))))
+	resp, err := u.send(http.MethodPatch, u.uploadURL, body, n, header)
+	if err == nil && resp.StatusCode == http.StatusConflict && u.offset != u.conflict {
+		resp.Body.Close()
+		u.conflict = u.offset
+		u.offset = -1
+		return nil, nil
+	}
+	if err != nil || !isSuccess(resp.StatusCode) {
+		return resp, err
+	}
+	resp.Body.Close()
+	u.last = resp
+	sent := u.offset
+	if err = u.setOffset(resp); err == nil && u.offset <= sent {
+		err = fmt.Errorf("%w: Upload-Offset %d did not advance", ErrTusProtocol, u.offset)
+	}
+	return nil, err
+}
+
+func (u *tusUpload) setOffset(resp *http.Response) error {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 || offset > u.size {
+		return fmt.Errorf("%w: invalid Upload-Offset %q", ErrTusProtocol, resp.Header.Get("Upload-Offset"))
+	}
+	u.offset = offset
+	return nil
+}
+
+// UploadTus uploads the file to the tus 1.0 endpoint at the target URL. The
+// upload is created by POST and sent by PATCH from the offset queried by
+// HEAD, so a failed request continues where it stopped. See
+// Response.UploadURL.
+func (h *Files) UploadTus() *Response {
+	return h.transfer("UploadTus", http.MethodPatch, h.uploadTus)
+}
+
+func (h *Files) uploadTus() *Response {
+	res := h.checkUpload()
+	if res.err == nil && h.filePath == "" {
+		res.err = ErrEmptyFilePath
+	}
+	if res.err == nil && h.tusChecksum != "" && newHash(h.tusChecksum) == nil {
+		res.err = fmt.Errorf("unsupported checksum algorithm %q", h.tusChecksum)
+	}
+	if res.err != nil {
+		return res
+	}
+	file, err := os.Open(h.filePath)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		res.err = err
+		return res
+	}
+	u := &tusUpload{
+		h:           h,
+		file:        file,
+		size:        stat.Size(),
+		fingerprint: tusFingerprint(h.targetURL, h.filePath, stat),
+		offset:      -1,
+		conflict:    -1,
+		p:           newProgress(h.progress, h.interval),
+		t:           newThrottle(h.rateLimit, h.limiter),
+	}
+	if h.tusStore != nil {
+		u.uploadURL, _ = h.tusStore.Get(u.fingerprint)
+	}
+	res.err = u.run()
+	res.resp = u.last
+	res.uploadURL = u.uploadURL
+	return res
+}
+
+// TerminateTus deletes the upload of the file recorded in the tus store, or
+// the upload at the target URL if no file path is given.
+func (h *Files) TerminateTus() *Response {
+	res := h.checkDownload()
+	if res.err != nil {
+		return res
+	}
+	uploadURL := h.targetURL
+	var fingerprint string
+	if h.filePath != "" {
+		stat, err := os.Stat(h.filePath)
+		if err != nil {
+			res.err = err
+			return res
+		}
+		fingerprint = tusFingerprint(h.targetURL, h.filePath, stat)
+		ok := false
+		if h.tusStore != nil {
+			uploadURL, ok = h.tusStore.Get(fingerprint)
+		}
+		if !ok {
+			res.err = ErrTusUploadNotFound
+			return res
+		}
+	}
+	res.resp, res.err = newRetrier(h.ctx, h.retry).do(h.httpClient(), func() (*http.Request, error) {
+		request, err := newRequest(h.ctx, http.MethodDelete, uploadURL, nil, "", h.header)
+		if err == nil {
+			request.Header.Set("Tus-Resumable", TusResumable)
+		}
+		return request, err
+	})
+	if res.err != nil {
+		return res
+	}
+	if res.resp.StatusCode != http.StatusNoContent {
+		res.err = newHTTPError(res.resp)
+		res.resp.Body.Close()
+		return res
+	}
+	res.resp.Body.Close()
+	res.uploadURL = uploadURL
+	if fingerprint != "" {
+		res.err = h.tusStore.Delete(fingerprint)
+	}
+	return res
+}